@@ -0,0 +1,225 @@
+package gotest_labels
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// The version of the cached discovery format, it shall be bumped on changing what is discovered from a file
+const cacheFormatVersion = "2"
+
+// The value of the TEST_LABELS_CACHE env variable to disable the cache
+const cacheDisabled = "off"
+
+// The version of a development build of gotest-labels, i.e. the main module, a local replacement or a workspace module
+const develVersion = "(devel)"
+
+// The cache entries not used for cacheMaxAge are removed, which is checked at most once per cacheTrimInterval
+const (
+	cacheMaxAge       = 5 * 24 * time.Hour
+	cacheTrimInterval = 24 * time.Hour
+	cacheTrimFile     = "trim.txt"
+)
+
+// The cache version of a development build of gotest-labels, whose discovery code may change without changing the
+// version, so the cache is disabled if it's empty
+var develCacheVersion = ""
+
+// The on-disk cache of the test functions discovered per file, shared by the test binaries of one go test run
+// and across runs. The TEST_LABELS_CACHE env variable relocates the cache directory, or disables the cache if it's
+// "off". The cache is under the user cache directory by default.
+//
+// The entries are keyed by the file path, size, modification time and content hash, along with the gotest-labels
+// version and the settings changing the discovery, so a stale entry is never read. The cache is disabled for a
+// development build of gotest-labels, which has no version. The entries are written to temporary files and renamed,
+// so the binaries running at once don't read partially written entries. The entries not used for 5 days are removed.
+type discoveryCache struct {
+	dir     string // The cache directory, empty if the cache is disabled
+	version string // The version of gotest-labels and the settings changing the discovery
+}
+
+func newDiscoveryCache() *discoveryCache {
+	version, ok := getCacheVersion()
+	if !ok {
+		return &discoveryCache{}
+	}
+	c := &discoveryCache{
+		dir:     getCacheDir(),
+		version: version,
+	}
+	c.trim()
+	return c
+}
+
+func getCacheDir() string {
+	dir := os.Getenv("TEST_LABELS_CACHE")
+	if dir == cacheDisabled {
+		return ""
+	}
+	if dir != "" {
+		return dir
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(userCacheDir, "gotest-labels")
+}
+
+// Get the version of the cache entries from the gotest-labels module version in the build info of the test binary,
+// and the settings which change the discovery of the same file. It returns false for a development build.
+func getCacheVersion() (string, bool) {
+	info, _ := debug.ReadBuildInfo()
+	version := getModuleVersion(info)
+	if version == develVersion {
+		version = develCacheVersion
+	}
+	if version == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s/%s/%t", cacheFormatVersion, version, getLabelMarker(), deriveLabelsEnabled()), true
+}
+
+// Get the version of gotest-labels in the build info, it's develVersion if the version doesn't identify the code
+func getModuleVersion(info *debug.BuildInfo) string {
+	if info == nil || info.Main.Path == modulePath {
+		return develVersion
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if dep.Version == "" {
+			return develVersion
+		}
+		return dep.Version
+	}
+	return develVersion
+}
+
+// Discover the test functions in the file, from the cache if the file is not changed
+func (c *discoveryCache) discoverFile(file string) (*fileDiscovery, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s, err: %v", file, err)
+	}
+	if c.dir == "" {
+		return discoverFile(file, src)
+	}
+
+	key, err := c.key(file, src)
+	if err != nil {
+		return discoverFile(file, src)
+	}
+	path := filepath.Join(c.dir, key[:2], key+".json")
+	if data, err := os.ReadFile(path); err == nil {
+		fd := &fileDiscovery{}
+		if err := json.Unmarshal(data, fd); err == nil && fd.Suites != nil {
+			touchCacheEntry(path)
+			return fd, nil
+		}
+	}
+
+	fd, err := discoverFile(file, src)
+	if err != nil {
+		return nil, err
+	}
+	// The cache is best effort, the discovery doesn't fail if the entry can't be written
+	_ = c.write(path, fd)
+	return fd, nil
+}
+
+// Get the cache key of the file from its path, size, modification time and content hash
+func (c *discoveryCache) key(file string, src []byte) (string, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	content := sha256.Sum256(src)
+
+	h := sha256.New()
+	for _, part := range []string{
+		c.version,
+		absFile,
+		file,
+		strconv.FormatInt(stat.Size(), 10),
+		strconv.FormatInt(stat.ModTime().UnixNano(), 10),
+		hex.EncodeToString(content[:]),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write the cache entry atomically by renaming a temporary file, so the concurrent writers of the same entry
+// don't corrupt it and the readers never see it partially written.
+func (c *discoveryCache) write(path string, fd *fileDiscovery) error {
+	data, err := json.Marshal(fd)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Mark the cache entry used by its modification time, which is only updated hourly to save the writes
+func touchCacheEntry(path string) {
+	now := time.Now()
+	if stat, err := os.Stat(path); err == nil && now.Sub(stat.ModTime()) > time.Hour {
+		_ = os.Chtimes(path, now, now)
+	}
+}
+
+// Remove the cache entries not used for cacheMaxAge, at most once per cacheTrimInterval as recorded by the
+// modification time of the trim file. Removing an entry another binary is using only costs its discovery again.
+func (c *discoveryCache) trim() {
+	if c.dir == "" {
+		return
+	}
+	marker := filepath.Join(c.dir, cacheTrimFile)
+	if stat, err := os.Stat(marker); err == nil && time.Since(stat.ModTime()) < cacheTrimInterval {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return
+	}
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		return
+	}
+
+	entries, _ := filepath.Glob(filepath.Join(c.dir, "*", "*.json"))
+	for _, entry := range entries {
+		if stat, err := os.Stat(entry); err == nil && time.Since(stat.ModTime()) > cacheMaxAge {
+			_ = os.Remove(entry)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package gotest_labels
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests of the package don't write the discovery cache of the user, the cache tests enable it in a temp dir
+func TestMain(m *testing.M) {
+	os.Setenv("TEST_LABELS_CACHE", cacheDisabled)
+	os.Exit(m.Run())
+}
+
+const cachedTestFile = `package demo
+
+import "testing"
+
+// @group=demo
+func TestCached(t *testing.T) {}
+`
+
+func writeCachedTestFile(t *testing.T, src string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "demo_test.go")
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// Enable the cache in a temp dir for the development build of gotest-labels the tests run with
+func useDiscoveryCache(t *testing.T) string {
+	t.Helper()
+
+	cacheDir := t.TempDir()
+	t.Setenv("TEST_LABELS_CACHE", cacheDir)
+	origVersion := develCacheVersion
+	t.Cleanup(func() { develCacheVersion = origVersion })
+	develCacheVersion = "test"
+	return cacheDir
+}
+
+// Count the cache entries under the cache directory
+func countCacheEntries(t *testing.T, dir string) int {
+	t.Helper()
+
+	entries, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	cacheDir := useDiscoveryCache(t)
+	file := writeCachedTestFile(t, cachedTestFile)
+
+	d, err := discoverTestFuncs([]string{file})
+	if err != nil {
+		t.Fatalf("discoverTestFuncs() failed: %v", err)
+	}
+	if countCacheEntries(t, cacheDir) != 1 {
+		t.Fatalf("Expected 1 cache entry after the first discovery")
+	}
+
+	cached, err := discoverTestFuncs([]string{file})
+	if err != nil {
+		t.Fatalf("discoverTestFuncs() from cache failed: %v", err)
+	}
+	if !maps.EqualFunc(d.funcs, cached.funcs, maps.Equal) || !maps.Equal(d.positions, cached.positions) {
+		t.Errorf("Expected the cached discovery %v to equal %v", cached.funcs, d.funcs)
+	}
+	if countCacheEntries(t, cacheDir) != 1 {
+		t.Errorf("Expected the cache entry to be reused")
+	}
+
+	// A changed file gets a new entry
+	if err := os.WriteFile(file, []byte(cachedTestFile+"\n// @group=other\nfunc TestOther(t *testing.T) {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := discoverTestFuncs([]string{file})
+	if err != nil {
+		t.Fatalf("discoverTestFuncs() of changed file failed: %v", err)
+	}
+	if changed.funcs["TestOther"]["group"] != "other" {
+		t.Errorf("Expected the changed file to be discovered again, got %v", changed.funcs)
+	}
+	if countCacheEntries(t, cacheDir) != 2 {
+		t.Errorf("Expected a new cache entry for the changed file")
+	}
+
+	// A different label marker doesn't read the entries of the default marker
+	t.Setenv("TEST_LABELS_MARKER", "+label:")
+	marked, err := discoverTestFuncs([]string{file})
+	if err != nil {
+		t.Fatalf("discoverTestFuncs() with custom marker failed: %v", err)
+	}
+	if len(marked.funcs["TestOther"]) != 0 {
+		t.Errorf("Expected no labels with the custom marker, got %v", marked.funcs["TestOther"])
+	}
+}
+
+func TestDiscoveryCacheCorruptedEntry(t *testing.T) {
+	cacheDir := useDiscoveryCache(t)
+	file := writeCachedTestFile(t, cachedTestFile)
+
+	if _, err := discoverTestFuncs([]string{file}); err != nil {
+		t.Fatalf("discoverTestFuncs() failed: %v", err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*.json"))
+	for _, entry := range entries {
+		if err := os.WriteFile(entry, []byte("{not json"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := discoverTestFuncs([]string{file})
+	if err != nil {
+		t.Fatalf("discoverTestFuncs() with corrupted entry failed: %v", err)
+	}
+	if d.funcs["TestCached"]["group"] != "demo" {
+		t.Errorf("Expected TestCached to be discovered again, got %v", d.funcs)
+	}
+}
+
+func TestDiscoveryCacheConcurrentWriters(t *testing.T) {
+	cacheDir := useDiscoveryCache(t)
+	file := writeCachedTestFile(t, cachedTestFile)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for range 16 {
+		wg.Go(func() {
+			d, err := newDiscoveryCache().discoverFile(file)
+			if err == nil && d.Funcs["TestCached"]["group"] != "demo" {
+				t.Errorf("Expected TestCached with group=demo, got %v", d.Funcs)
+			}
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("discoverFile() failed: %v", err)
+		}
+	}
+
+	if countCacheEntries(t, cacheDir) != 1 {
+		t.Errorf("Expected 1 cache entry")
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*.tmp")); len(tmps) != 0 {
+		t.Errorf("Expected no temporary files left, got %v", tmps)
+	}
+}
+
+func TestDiscoveryCacheDisabled(t *testing.T) {
+	t.Setenv("TEST_LABELS_CACHE", "off")
+
+	if dir := newDiscoveryCache().dir; dir != "" {
+		t.Errorf("Expected the cache to be disabled, got %s", dir)
+	}
+}
+
+func TestGetModuleVersion(t *testing.T) {
+	dep := func(version string, replace *debug.Module) *debug.Module {
+		return &debug.Module{Path: modulePath, Version: version, Replace: replace}
+	}
+	tests := []struct {
+		name string
+		info *debug.BuildInfo
+		want string
+	}{
+		{name: "no build info", want: develVersion},
+		{name: "main module", info: &debug.BuildInfo{Main: debug.Module{Path: modulePath}}, want: develVersion},
+		{name: "released", info: &debug.BuildInfo{Deps: []*debug.Module{dep("v1.2.0", nil)}}, want: "v1.2.0"},
+		{name: "replaced by a local path", want: develVersion,
+			info: &debug.BuildInfo{Deps: []*debug.Module{dep("v1.2.0", &debug.Module{Path: "../gotest-labels"})}}},
+		{name: "replaced by a version", want: "v1.3.0",
+			info: &debug.BuildInfo{Deps: []*debug.Module{dep("v1.2.0", &debug.Module{Path: "fork", Version: "v1.3.0"})}}},
+		{name: "workspace module", info: &debug.BuildInfo{Deps: []*debug.Module{dep(develVersion, nil)}}, want: develVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getModuleVersion(tt.info); got != tt.want {
+				t.Errorf("getModuleVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryCacheDevelBuild(t *testing.T) {
+	cacheDir := useDiscoveryCache(t)
+	develCacheVersion = ""
+
+	if _, err := discoverTestFuncs([]string{writeCachedTestFile(t, cachedTestFile)}); err != nil {
+		t.Fatalf("discoverTestFuncs() failed: %v", err)
+	}
+	if n := countCacheEntries(t, cacheDir); n != 0 {
+		t.Errorf("Expected no cache entry of the development build, got %d", n)
+	}
+}
+
+func TestDiscoveryCacheTrim(t *testing.T) {
+	cacheDir := useDiscoveryCache(t)
+	file := writeCachedTestFile(t, cachedTestFile)
+	if _, err := discoverTestFuncs([]string{file}); err != nil {
+		t.Fatalf("discoverTestFuncs() failed: %v", err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*.json"))
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 cache entry, got %v", entries)
+	}
+
+	// The entry used recently is kept, even if the trim is due
+	old := time.Now().Add(-cacheTrimInterval - time.Hour)
+	if err := os.Chtimes(filepath.Join(cacheDir, cacheTrimFile), old, old); err != nil {
+		t.Fatal(err)
+	}
+	newDiscoveryCache()
+	if countCacheEntries(t, cacheDir) != 1 {
+		t.Fatalf("Expected the recent cache entry to be kept")
+	}
+
+	// The entry not used for long is removed on the next trim only
+	unused := time.Now().Add(-cacheMaxAge - time.Hour)
+	if err := os.Chtimes(entries[0], unused, unused); err != nil {
+		t.Fatal(err)
+	}
+	newDiscoveryCache()
+	if countCacheEntries(t, cacheDir) != 1 {
+		t.Fatalf("Expected the cache not to be trimmed again within the interval")
+	}
+	if err := os.Chtimes(filepath.Join(cacheDir, cacheTrimFile), old, old); err != nil {
+		t.Fatal(err)
+	}
+	newDiscoveryCache()
+	if countCacheEntries(t, cacheDir) != 0 {
+		t.Errorf("Expected the unused cache entry to be removed")
+	}
+}
@@ -1,17 +1,33 @@
 package gotest_labels
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
 )
 
 type cliArgs struct {
-	runRegex  *regexp.Regexp // The regex pattern for -run or -list
-	listMode  bool           // Whether the -list flag is used
-	labels    string         // The labels filter from the -labels flag or TEST_LABELS env variable
-	labelsAST Node           // The parsed AST of the labels filter
+	runPattern   *namePattern   // The name pattern for -run, nil if all the tests run
+	benchPattern *namePattern   // The name pattern for -bench, nil if benchmarks are not run
+	fuzzPattern  *namePattern   // The name pattern for -fuzz, nil if not fuzzing
+	skipPattern  *namePattern   // The name pattern for -skip, nil if no test is skipped
+	listRegex    *regexp.Regexp // The regex pattern for -list, which matches the top-level names only
+	testifyRegex *regexp.Regexp // The regex pattern for testify's -testify.m
+	listMode     bool           // Whether the -list flag is used
+	labels       string         // The labels filter from the -labels flag or TEST_LABELS env variable
+	labelsAST    Node           // The parsed AST of the labels filter
+	strict       bool           // Whether the malformed labels fail the test run, from -labels-strict or TEST_LABELS_STRICT
+	schema       *LabelSchema   // The label schema of the module, nil if there's none
+	sourceDir    string         // The source directory of the package under test
+	sourceErr    error          // The error of the package source which is not found
+	buildTags    []string       // The build tags of the test binary, sorted
+	labelsErr    error          // The error of the invalid labels filter, nil if it's valid or empty
+	err          error          // The error of the invalid patterns and labels filter given by the user
 }
 
 func (c *cliArgs) labelsEnabled() bool {
@@ -19,27 +35,42 @@ func (c *cliArgs) labelsEnabled() bool {
 }
 
 func (c *cliArgs) buildLabelsAST() {
+	c.labelsAST, c.labelsErr = nil, nil
 	if c.labels == "" {
-		c.labelsAST = nil
 		return
 	}
-	ast, err := ParseLabelExp(c.labels)
+	ast, err := ParseLabelExpWithSchema(c.labels, c.schema)
 	if err != nil {
-		fmt.Println("Error parsing label expression:", err)
+		c.labelsErr = fmt.Errorf("invalid label expression %q: %v", c.labels, err)
 	} else {
 		c.labelsAST = ast
 	}
 }
 
 func NewCliArgs() *cliArgs {
+	strict, _ := strconv.ParseBool(os.Getenv("TEST_LABELS_STRICT"))
 	cliArgs := &cliArgs{
 		labels: os.Getenv("TEST_LABELS"),
+		strict: strict,
+	}
+	// The schema belongs to the module of the package under test, which isn't the current directory's if the test
+	// binary runs elsewhere. Without the package source, the missing source is reported by the discovery.
+	info, _ := debug.ReadBuildInfo()
+	cliArgs.sourceDir, cliArgs.sourceErr = findSourceDir(info)
+	cliArgs.buildTags = getBuildTags(info)
+	if cliArgs.sourceErr == nil {
+		schema, err := LoadLabelSchema(cliArgs.sourceDir)
+		if err != nil {
+			log.Printf("Error loading label schema: %v", err)
+		}
+		cliArgs.schema = schema
 	}
 	cliArgs.buildLabelsAST()
 	return cliArgs
 }
 
-// Parse the os.Args for -run, -list, -json and the new added -labels flags in go test command.
+// Parse the os.Args for -run, -skip, -bench, -fuzz, -list, -json, testify's -testify.m and the new added -labels and
+// -labels-strict flags in go test command.
 func ParseOSArgs() *cliArgs {
 	defer removeLabelFlags()
 
@@ -49,6 +80,11 @@ func ParseOSArgs() *cliArgs {
 func parseArgs(osArgs []string) *cliArgs {
 	cliArgs := NewCliArgs()
 	runPattern := ""
+	benchPattern := ""
+	fuzzPattern := ""
+	skipPattern := ""
+	listPattern := ""
+	testifyPattern := ""
 	args := osArgs[1:]
 
 	for i := 0; i < len(args); i++ {
@@ -65,16 +101,69 @@ func parseArgs(osArgs []string) *cliArgs {
 			continue
 		}
 
+		if arg == "-test.bench" {
+			if i+1 < len(args) {
+				benchPattern = args[i+1]
+				i++
+			}
+			continue
+		} else if strings.HasPrefix(arg, "-test.bench=") {
+			benchPattern = strings.TrimPrefix(arg, "-test.bench=")
+			continue
+		}
+
+		if arg == "-test.fuzz" {
+			if i+1 < len(args) {
+				fuzzPattern = args[i+1]
+				i++
+			}
+			continue
+		} else if strings.HasPrefix(arg, "-test.fuzz=") {
+			fuzzPattern = strings.TrimPrefix(arg, "-test.fuzz=")
+			continue
+		}
+
+		if arg == "-test.skip" {
+			if i+1 < len(args) {
+				skipPattern = args[i+1]
+				i++
+			}
+			continue
+		} else if strings.HasPrefix(arg, "-test.skip=") {
+			skipPattern = strings.TrimPrefix(arg, "-test.skip=")
+			continue
+		}
+
+		if arg == "-testify.m" {
+			if i+1 < len(args) {
+				testifyPattern = args[i+1]
+				i++
+			}
+			continue
+		} else if strings.HasPrefix(arg, "-testify.m=") {
+			testifyPattern = strings.TrimPrefix(arg, "-testify.m=")
+			continue
+		}
+
 		if arg == "-test.list" {
 			cliArgs.listMode = true
 			if i+1 < len(args) {
-				runPattern = args[i+1]
+				listPattern = args[i+1]
 				i++
 			}
 			continue
 		} else if strings.HasPrefix(arg, "-test.list=") {
 			cliArgs.listMode = true
-			runPattern = strings.TrimPrefix(arg, "-test.list=")
+			listPattern = strings.TrimPrefix(arg, "-test.list=")
+			continue
+		}
+
+		// -labels-strict flag overwrites the value from TEST_LABELS_STRICT env var
+		if arg == "-labels-strict" {
+			cliArgs.strict = true
+			continue
+		} else if strings.HasPrefix(arg, "-labels-strict=") {
+			cliArgs.strict, _ = strconv.ParseBool(strings.TrimPrefix(arg, "-labels-strict="))
 			continue
 		}
 
@@ -95,16 +184,39 @@ func parseArgs(osArgs []string) *cliArgs {
 		}
 	}
 
-	if runPattern != "" {
-		cliArgs.runRegex = regexp.MustCompile(runPattern)
+	// The invalid patterns are reported as errors instead of panics, the same patterns fail the go test run anyway
+	var errs []error
+	var err error
+	if cliArgs.runPattern, err = compileNamePattern("test.run", runPattern); err != nil {
+		errs = append(errs, err)
 	}
-
+	if cliArgs.skipPattern, err = compileNamePattern("test.skip", skipPattern); err != nil {
+		errs = append(errs, err)
+	}
+	if cliArgs.benchPattern, err = compileNamePattern("test.bench", benchPattern); err != nil {
+		errs = append(errs, err)
+	}
+	if cliArgs.fuzzPattern, err = compileNamePattern("test.fuzz", fuzzPattern); err != nil {
+		errs = append(errs, err)
+	}
+	if listPattern != "" {
+		if cliArgs.listRegex, err = regexp.Compile(listPattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid regexp for -test.list (%q): %v", listPattern, err))
+		}
+	}
+	if testifyPattern != "" {
+		if cliArgs.testifyRegex, err = regexp.Compile(testifyPattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid regexp for -testify.m (%q): %v", testifyPattern, err))
+		}
+	}
+	// The invalid labels filter, e.g. a value not allowed by the schema, fails the run rather than running all tests
 	cliArgs.buildLabelsAST()
+	cliArgs.err = errors.Join(append(errs, cliArgs.labelsErr)...)
 
 	return cliArgs
 }
 
-// Remove the -labels flag from os.Args after parsing it. This flag isn't a std go test flag.
+// Remove the -labels and -labels-strict flags from os.Args after parsing them. These flags aren't std go test flags.
 func removeLabelFlags() {
 	os.Args = removeLabelFlagsFromArgs(os.Args)
 }
@@ -116,7 +228,8 @@ func removeLabelFlagsFromArgs(args []string) []string {
 			i++
 			continue
 		}
-		if strings.HasPrefix(args[i], "-labels=") {
+		if strings.HasPrefix(args[i], "-labels=") || args[i] == "-labels-strict" ||
+			strings.HasPrefix(args[i], "-labels-strict=") {
 			continue
 		}
 		newArgs = append(newArgs, args[i])
@@ -1,7 +1,7 @@
 package gotest_labels
 
 import (
-	"regexp"
+	"path/filepath"
 	"slices"
 	"testing"
 )
@@ -163,36 +163,59 @@ func TestRemoveLabelFlagsFromArgsWithEqualSign(t *testing.T) {
 
 func TestParseArgs(t *testing.T) {
 	tests := []struct {
-		name     string
-		osArgs   []string
-		expected *cliArgs
+		name         string
+		osArgs       []string
+		runPattern   string
+		benchPattern string
+		skipPattern  string
+		listRegex    string
+		listMode     bool
+		labels       string
+		wantErr      bool
 	}{
 		{
-			name:   "Test run pattern with -test.run flag",
-			osArgs: []string{"program", "-test.run", "TestPattern"},
-			expected: &cliArgs{
-				runRegex: regexp.MustCompile("TestPattern"),
-				listMode: false,
-				labels:   "",
-			},
+			name:       "Test run pattern with -test.run flag",
+			osArgs:     []string{"program", "-test.run", "TestPattern"},
+			runPattern: "TestPattern",
 		},
 		{
-			name:   "Test list mode with -test.list flag",
-			osArgs: []string{"program", "-test.list", "ListPattern"},
-			expected: &cliArgs{
-				runRegex: regexp.MustCompile("ListPattern"),
-				listMode: true,
-				labels:   "",
-			},
+			name:      "Test list mode with -test.list flag",
+			osArgs:    []string{"program", "-test.list", "ListPattern"},
+			listRegex: "ListPattern",
+			listMode:  true,
+		},
+		{
+			name:         "Test bench pattern with -test.bench flag",
+			osArgs:       []string{"program", "-test.run=^$", "-test.bench", "Hotpath"},
+			runPattern:   "^$",
+			benchPattern: "Hotpath",
+		},
+		{
+			name:        "Test skip pattern with -test.skip flag",
+			osArgs:      []string{"program", "-test.skip=TestA/eu"},
+			skipPattern: "TestA/eu",
 		},
 		{
 			name:   "Test labels with -labels flag",
 			osArgs: []string{"program", "-labels", "env=prod"},
-			expected: &cliArgs{
-				runRegex: nil,
-				listMode: false,
-				labels:   "env=prod",
-			},
+			labels: "env=prod",
+		},
+		{
+			name:    "Invalid run pattern",
+			osArgs:  []string{"program", "-test.run", "TestA/(case"},
+			wantErr: true,
+		},
+		{
+			name:     "Invalid list pattern",
+			osArgs:   []string{"program", "-test.list=Test[", "-labels", "env=prod"},
+			listMode: true,
+			labels:   "env=prod",
+			wantErr:  true,
+		},
+		{
+			name:    "Invalid testify pattern",
+			osArgs:  []string{"program", "-testify.m", "*Create"},
+			wantErr: true,
 		},
 	}
 
@@ -200,20 +223,103 @@ func TestParseArgs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			result := parseArgs(tt.osArgs)
 
-			// Compare runRegex separately as it is a pointer
-			if (result.runRegex == nil && tt.expected.runRegex != nil) || (result.runRegex != nil && tt.expected.runRegex == nil) {
-				t.Errorf("runRegex mismatch: got %v, want %v", result.runRegex, tt.expected.runRegex)
-			} else if result.runRegex != nil && tt.expected.runRegex != nil && result.runRegex.String() != tt.expected.runRegex.String() {
-				t.Errorf("runRegex mismatch: got %v, want %v", result.runRegex.String(), tt.expected.runRegex.String())
+			if (result.err != nil) != tt.wantErr {
+				t.Errorf("err mismatch: got %v, wantErr %v", result.err, tt.wantErr)
+			}
+			if result.runPattern.String() != tt.runPattern {
+				t.Errorf("runPattern mismatch: got %v, want %v", result.runPattern, tt.runPattern)
+			}
+			if result.benchPattern.String() != tt.benchPattern {
+				t.Errorf("benchPattern mismatch: got %v, want %v", result.benchPattern, tt.benchPattern)
+			}
+			if result.skipPattern.String() != tt.skipPattern {
+				t.Errorf("skipPattern mismatch: got %v, want %v", result.skipPattern, tt.skipPattern)
+			}
+			var listRegex string
+			if result.listRegex != nil {
+				listRegex = result.listRegex.String()
+			}
+			if listRegex != tt.listRegex {
+				t.Errorf("listRegex mismatch: got %v, want %v", listRegex, tt.listRegex)
 			}
 
 			// Compare other fields
-			if result.listMode != tt.expected.listMode {
-				t.Errorf("listMode mismatch: got %v, want %v", result.listMode, tt.expected.listMode)
+			if result.listMode != tt.listMode {
+				t.Errorf("listMode mismatch: got %v, want %v", result.listMode, tt.listMode)
+			}
+			if result.labels != tt.labels {
+				t.Errorf("labels mismatch: got %v, want %v", result.labels, tt.labels)
+			}
+		})
+	}
+}
+
+func TestParseArgsStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		osArgs []string
+		want   bool
+	}{
+		{name: "default", osArgs: []string{"program"}, want: false},
+		{name: "env", env: "true", osArgs: []string{"program"}, want: true},
+		{name: "flag", osArgs: []string{"program", "-labels-strict", "-labels", "env=prod"}, want: true},
+		{name: "flag overrides env", env: "1", osArgs: []string{"program", "-labels-strict=false"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_LABELS_STRICT", tt.env)
+
+			if got := parseArgs(tt.osArgs).strict; got != tt.want {
+				t.Errorf("parseArgs(%v).strict = %v, want %v", tt.osArgs, got, tt.want)
 			}
-			if result.labels != tt.expected.labels {
-				t.Errorf("labels mismatch: got %v, want %v", result.labels, tt.expected.labels)
+		})
+	}
+
+	args := removeLabelFlagsFromArgs([]string{"-test.v", "-labels-strict", "-labels-strict=true", "-test.run", "Alpha"})
+	if !slices.Equal(args, []string{"-test.v", "-test.run", "Alpha"}) {
+		t.Errorf("Expected [-test.v -test.run Alpha], got %v", args)
+	}
+}
+
+func TestParseArgsSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		osArgs  []string
+		wantErr bool
+	}{
+		{name: "allowed value", osArgs: []string{"program", "-labels", "env=prod"}},
+		{name: "disallowed value", osArgs: []string{"program", "-labels", "env=staging"}, wantErr: true},
+		{name: "undeclared key", osArgs: []string{"program", "-labels", "team=core"}, wantErr: true},
+		{name: "invalid syntax", osArgs: []string{"program", "-labels", "env=prod &&"}, wantErr: true},
+	}
+
+	t.Setenv("TEST_LABELS_SRC", writeSchemaModule(t, testSchema))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := parseArgs(tt.osArgs)
+			if (args.err != nil) != tt.wantErr {
+				t.Errorf("parseArgs(%v).err = %v, wantErr %v", tt.osArgs, args.err, tt.wantErr)
+			}
+			if args.labelsEnabled() == tt.wantErr {
+				t.Errorf("parseArgs(%v).labelsEnabled() = %v, want %v", tt.osArgs, args.labelsEnabled(), !tt.wantErr)
 			}
 		})
 	}
+
+	// The schema of the current directory's module doesn't apply to the package source elsewhere
+	t.Run("schema of the package source", func(t *testing.T) {
+		src, err := filepath.Abs("./examples/simple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TEST_LABELS_SRC", src)
+		t.Chdir(writeSchemaModule(t, testSchema))
+
+		args := parseArgs([]string{"program", "-labels", "group=demo"})
+		if args.err != nil || args.schema != nil {
+			t.Errorf("Expected no schema of %s, got %v and %+v", src, args.err, args.schema)
+		}
+	})
 }
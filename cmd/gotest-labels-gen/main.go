@@ -0,0 +1,29 @@
+// The gotest-labels-gen command generates the static label table of a package into zz_testlabels_test.go, so the
+// test binary selects tests by labels without its source tree, e.g. built by `go test -c` and run elsewhere.
+// It's run by go generate in the package directory:
+//
+//	//go:generate go run github.com/maxwu/gotest-labels/cmd/gotest-labels-gen
+//
+// The test binary shall be built with the same build tags as the table, given by the -tags flag, e.g.
+// `-tags=integration` for `go test -c -tags=integration`. Otherwise the binary fails to select the tests by labels.
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	gotest_labels "github.com/maxwu/gotest-labels"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "the package directory to generate the label table for")
+	tags := flag.String("tags", "", "the comma-separated build tags of the test binary")
+	flag.Parse()
+
+	path, err := gotest_labels.GenerateEmbeddedLabels(*dir, strings.Split(*tags, ","))
+	if err != nil {
+		log.Fatalf("Error generating the label table: %v", err)
+	}
+	log.Printf("Generated %s", path)
+}
@@ -0,0 +1,262 @@
+package gotest_labels
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/format"
+	"go/token"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EmbeddedFileName is the test file generated by the gotest-labels-gen command with the static label table of the
+// package, for the test binaries run without the source tree, e.g. built by `go test -c` and shipped elsewhere:
+//
+//	//go:generate go run github.com/maxwu/gotest-labels/cmd/gotest-labels-gen
+const EmbeddedFileName = "zz_testlabels_test.go"
+
+// EmbeddedLabels is the static label table of a package generated into EmbeddedFileName
+type EmbeddedLabels struct {
+	Package      string                // The import path of the package
+	Hash         string                // The hash of the test files and the manifest the table is generated from
+	Tags         []string              // The build tags the test files are selected with, sorted
+	Tests        map[string]TestLabels // The tests and labeled subtests with their labels
+	RuntimeTests []string              // The tests with subtests labeled at runtime by Run
+	SuiteMethods []string              // The testify suite methods, e.g. `TestMySuite/TestCreate`
+}
+
+// The embedded label table, and whether MutateTestFilterByLabels already ran without it
+var embedded struct {
+	sync.Mutex
+	labels  *EmbeddedLabels
+	mutated bool
+}
+
+// Embed sets the static label table of the package under test, which is preferred over parsing the test files.
+// If the test files are present and changed since the table was generated, the table is stale and the test files
+// are parsed instead. It's called by the init function of the generated EmbeddedFileName.
+//
+// The init functions of the test package run after the apply package, so the table is only used if
+// MutateTestFilterByLabels is called from TestMain.
+func Embed(labels *EmbeddedLabels) {
+	embedded.Lock()
+	defer embedded.Unlock()
+	embedded.labels = labels
+	if embedded.mutated {
+		log.Printf("Warning: the embedded labels of %s are set after the test filter is mutated, "+
+			"call MutateTestFilterByLabels from TestMain instead of importing the apply package", labels.Package)
+	}
+}
+
+// Get the embedded label table and record that the test filter is mutated
+func getEmbeddedLabels() *EmbeddedLabels {
+	embedded.Lock()
+	defer embedded.Unlock()
+	embedded.mutated = true
+	return embedded.labels
+}
+
+// Get the discovery of the package directory from the embedded table. It returns nil if there's no table for
+// the package, or the test files are present and changed since the table was generated. Without the test files,
+// the table generated with other build tags than the binary's fails, as its tests may not be in the binary.
+func (e *EmbeddedLabels) discovery(dir string, files []string, tags []string) (*discovery, error) {
+	if e == nil {
+		return nil, nil
+	}
+	if files != nil && getDirImportPath(dir) != e.Package {
+		return nil, nil
+	}
+	if !slices.Equal(normalizeTags(e.Tags), normalizeTags(tags)) {
+		if files == nil {
+			return nil, fmt.Errorf("the embedded labels of %s are generated with the build tags %q, but the "+
+				"test binary is built with %q, generate %s with the -tags of the binary", e.Package,
+				strings.Join(e.Tags, ","), strings.Join(tags, ","), EmbeddedFileName)
+		}
+		log.Printf("Warning: the embedded labels of %s are generated with other build tags than the test binary",
+			e.Package)
+		return nil, nil
+	}
+	if files != nil {
+		if hash, err := hashTestFiles(dir, files); err != nil || hash != e.Hash {
+			log.Printf("Warning: the embedded labels of %s are stale, run go generate to update %s",
+				e.Package, EmbeddedFileName)
+			return nil, nil
+		}
+	}
+
+	d := &discovery{
+		funcs:        make(map[string]TestLabels, len(e.Tests)),
+		runtimeTests: map[string]bool{},
+		suiteMethods: map[string]bool{},
+		positions:    map[string]token.Position{},
+	}
+	for name, labels := range e.Tests {
+		d.funcs[name] = maps.Clone(labels)
+	}
+	for _, name := range e.RuntimeTests {
+		d.runtimeTests[name] = true
+	}
+	for _, name := range e.SuiteMethods {
+		d.suiteMethods[name] = true
+	}
+	return d, nil
+}
+
+// Sort the build tags and drop the empty and duplicate ones, to compare the tags regardless of their order
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// Get the build tags the binary is built with by -tags, or set by the TEST_LABELS_TAGS env variable
+func getBuildTags(info *debug.BuildInfo) []string {
+	buildFlags, _ := getBuildSettings(info)
+	for _, flag := range buildFlags {
+		if tags, ok := strings.CutPrefix(flag, "-tags="); ok {
+			return normalizeTags(strings.Split(tags, ","))
+		}
+	}
+	return nil
+}
+
+// Hash the test files of the package and its manifest, except the generated EmbeddedFileName
+func hashTestFiles(dir string, files []string) (string, error) {
+	paths := slices.Sorted(slices.Values(files))
+	paths = append(paths, filepath.Join(dir, ManifestFileName))
+
+	h := sha256.New()
+	for _, path := range paths {
+		if filepath.Base(path) == EmbeddedFileName {
+			continue
+		}
+		src, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) && filepath.Base(path) == ManifestFileName {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.Base(path), len(src))
+		h.Write(src)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GenerateEmbeddedLabels writes EmbeddedFileName with the static label table of the package in the directory.
+// The tests are discovered from the test files selected by the build tags, which shall be the -tags of the test
+// binary, and the manifest. The other label sources are applied at runtime. It returns the path of the generated file.
+func GenerateEmbeddedLabels(dir string, tags []string) (string, error) {
+	tags = normalizeTags(tags)
+	ctx := build.Default
+	ctx.BuildTags = tags
+	pkg, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to list the package in %s, err: %v", dir, err)
+	}
+	var files []string
+	for _, file := range append(pkg.TestGoFiles, pkg.XTestGoFiles...) {
+		if file != EmbeddedFileName {
+			files = append(files, filepath.Join(pkg.Dir, file))
+		}
+	}
+	pkgPath := getDirImportPath(dir)
+	if pkgPath == "" {
+		return "", fmt.Errorf("failed to get the import path of %s", dir)
+	}
+
+	d, err := discoverTestFuncs(files)
+	if err != nil {
+		return "", err
+	}
+	for _, diagnostic := range d.diagnostics {
+		log.Printf("Warning: %s", diagnostic)
+	}
+	if err := applyLabelSources([]LabelSource{ManifestSource{}}, dir, d.funcs); err != nil {
+		return "", err
+	}
+	hash, err := hashTestFiles(dir, files)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := renderEmbeddedLabels(pkg.Name, &EmbeddedLabels{
+		Package:      pkgPath,
+		Hash:         hash,
+		Tags:         tags,
+		Tests:        d.funcs,
+		RuntimeTests: slices.Sorted(maps.Keys(d.runtimeTests)),
+		SuiteMethods: slices.Sorted(maps.Keys(d.suiteMethods)),
+	})
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, EmbeddedFileName)
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write %s, err: %v", path, err)
+	}
+	return path, nil
+}
+
+// Render the source of EmbeddedFileName with the label table in a stable order
+func renderEmbeddedLabels(pkgName string, e *EmbeddedLabels) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gotest-labels-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkgName)
+	fmt.Fprintf(&b, "import gotest_labels %s\n\n", strconv.Quote(modulePath))
+	fmt.Fprintf(&b, "func init() {\n\tgotest_labels.Embed(&gotest_labels.EmbeddedLabels{\n")
+	fmt.Fprintf(&b, "Package: %s,\n", strconv.Quote(e.Package))
+	fmt.Fprintf(&b, "Hash: %s,\n", strconv.Quote(e.Hash))
+	if len(e.Tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s,\n", renderStrings(e.Tags))
+	}
+	fmt.Fprintf(&b, "Tests: map[string]gotest_labels.TestLabels{\n")
+	for _, name := range slices.Sorted(maps.Keys(e.Tests)) {
+		fmt.Fprintf(&b, "%s: {", strconv.Quote(name))
+		for i, key := range slices.Sorted(maps.Keys(e.Tests[name])) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s: %s", strconv.Quote(key), strconv.Quote(e.Tests[name][key]))
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("},\n")
+	for _, field := range []struct {
+		name  string
+		names []string
+	}{{"RuntimeTests", e.RuntimeTests}, {"SuiteMethods", e.SuiteMethods}} {
+		if len(field.names) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s,\n", field.name, renderStrings(field.names))
+	}
+	b.WriteString("})\n}\n")
+	return format.Source(b.Bytes())
+}
+
+// Render the string slice literal of the names
+func renderStrings(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = strconv.Quote(name)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
@@ -0,0 +1,296 @@
+package gotest_labels
+
+import (
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGenerateEmbeddedLabels(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/rig\n",
+		"rig_test.go": `package rig
+
+import "testing"
+
+// @group=rig
+func TestRig(t *testing.T) {
+	t.Run("eu", func(t *testing.T) {}) // @region=eu
+}
+
+func TestDesk(t *testing.T) {}
+`,
+		ManifestFileName: `{"TestDesk": {"group": "desk"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := GenerateEmbeddedLabels(dir, nil)
+	if err != nil {
+		t.Fatalf("GenerateEmbeddedLabels() error = %v", err)
+	}
+	if path != filepath.Join(dir, EmbeddedFileName) {
+		t.Errorf("GenerateEmbeddedLabels() = %s, want %s", path, filepath.Join(dir, EmbeddedFileName))
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), path, src, 0); err != nil {
+		t.Fatalf("the generated file doesn't parse: %v", err)
+	}
+	for _, want := range []string{
+		"// Code generated by gotest-labels-gen. DO NOT EDIT.",
+		"package rig",
+		`Package: "example.com/rig"`,
+		`"TestDesk": {"group": "desk"}`,
+		`"TestRig": {"group": "rig"}`,
+		`"TestRig/eu": {"group": "rig", "region": "eu"}`,
+	} {
+		// The entries are aligned by gofmt
+		if !strings.Contains(strings.Join(strings.Fields(string(src)), " "), want) {
+			t.Errorf("the generated file doesn't contain %q:\n%s", want, src)
+		}
+	}
+
+	// The generation is stable, so the generated file only changes with the labels
+	if _, err := GenerateEmbeddedLabels(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(src) {
+		t.Errorf("the generated file changed without changing the tests:\n%s", again)
+	}
+
+	// The test files are selected by the build tags of the test binary, which are recorded in the table
+	tagged := "//go:build integration\n\npackage rig\n\nimport \"testing\"\n\n" +
+		"// @group=rig\nfunc TestIntegration(t *testing.T) {}\n"
+	if err := os.WriteFile(filepath.Join(dir, "integration_test.go"), []byte(tagged), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateEmbeddedLabels(dir, []string{"rig", "", "integration"}); err != nil {
+		t.Fatal(err)
+	}
+	src, _ = os.ReadFile(path)
+	for _, want := range []string{`Tags: []string{"integration", "rig"}`, `"TestIntegration": {"group": "rig"}`} {
+		if !strings.Contains(strings.Join(strings.Fields(string(src)), " "), want) {
+			t.Errorf("the generated file doesn't contain %q:\n%s", want, src)
+		}
+	}
+}
+
+func TestGetBuildTags(t *testing.T) {
+	info := &debug.BuildInfo{Settings: []debug.BuildSetting{{Key: "-tags", Value: "rig,integration"}}}
+	if got := getBuildTags(info); !slices.Equal(got, []string{"integration", "rig"}) {
+		t.Errorf("getBuildTags() = %v, want [integration rig]", got)
+	}
+	if got := getBuildTags(nil); got != nil {
+		t.Errorf("getBuildTags() = %v, want no tags", got)
+	}
+	t.Setenv("TEST_LABELS_TAGS", "desk")
+	if got := getBuildTags(info); !slices.Equal(got, []string{"desk"}) {
+		t.Errorf("getBuildTags() = %v, want [desk]", got)
+	}
+}
+
+func TestRenderEmbeddedLabels(t *testing.T) {
+	e := &EmbeddedLabels{
+		Package:      "example.com/rig",
+		Tests:        map[string]TestLabels{"TestRig": {"group": "rig"}, "TestSuite/TestCreate": {"group": "rig"}},
+		RuntimeTests: []string{"TestRig"},
+		SuiteMethods: []string{"TestSuite/TestCreate"},
+	}
+	want, err := renderEmbeddedLabels("rig", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Index(string(want), "RuntimeTests:") > strings.Index(string(want), "SuiteMethods:") {
+		t.Errorf("Expected RuntimeTests before SuiteMethods:\n%s", want)
+	}
+	for range 10 {
+		if got, _ := renderEmbeddedLabels("rig", e); string(got) != string(want) {
+			t.Fatalf("Expected the same source of the same table, got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestEmbeddedLabelsDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/rig\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "rig_test.go")
+	if err := os.WriteFile(file, []byte("package rig\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{file, filepath.Join(dir, EmbeddedFileName)}
+	hash, err := hashTestFiles(dir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := &EmbeddedLabels{
+		Package:      "example.com/rig",
+		Hash:         hash,
+		Tests:        map[string]TestLabels{"TestRig": {"group": "rig"}, "TestRig/eu": {"group": "rig", "region": "eu"}},
+		RuntimeTests: []string{"TestRig"},
+	}
+
+	t.Run("No embedded table", func(t *testing.T) {
+		if d, err := (*EmbeddedLabels)(nil).discovery(dir, files, nil); d != nil || err != nil {
+			t.Errorf("Expected no discovery without an embedded table, got %v", err)
+		}
+	})
+
+	t.Run("Sources are not reachable", func(t *testing.T) {
+		d, err := embedded.discovery(".", nil, nil)
+		if d == nil || err != nil {
+			t.Fatalf("Expected the embedded table to be used without sources, got %v", err)
+		}
+		if d.funcs["TestRig/eu"]["region"] != "eu" || !d.runtimeTests["TestRig"] {
+			t.Errorf("Unexpected discovery %+v", d)
+		}
+		d.funcs["TestRig"]["group"] = "changed"
+		if embedded.Tests["TestRig"]["group"] != "rig" {
+			t.Errorf("Expected the discovery not to share the labels of the embedded table")
+		}
+	})
+
+	t.Run("Sources are not changed", func(t *testing.T) {
+		if d, err := embedded.discovery(dir, files, nil); d == nil || err != nil {
+			t.Errorf("Expected the embedded table to be used with unchanged sources, got %v", err)
+		}
+	})
+
+	t.Run("Another package", func(t *testing.T) {
+		if d, _ := embedded.discovery("./examples/simple", files, nil); d != nil {
+			t.Errorf("Expected the embedded table not to be used for another package")
+		}
+	})
+
+	t.Run("Other build tags", func(t *testing.T) {
+		tagged := *embedded
+		tagged.Tags = []string{"integration"}
+		if d, err := tagged.discovery(dir, files, nil); d != nil || err != nil {
+			t.Errorf("Expected the test files to be parsed instead, got %v", err)
+		}
+		if _, err := tagged.discovery(".", nil, nil); err == nil || !strings.Contains(err.Error(), "integration") {
+			t.Errorf("Expected the table of other build tags to fail without sources, got %v", err)
+		}
+		if d, err := tagged.discovery(".", nil, []string{"integration"}); d == nil || err != nil {
+			t.Errorf("Expected the table of the same build tags to be used, got %v", err)
+		}
+	})
+
+	t.Run("Sources are changed", func(t *testing.T) {
+		if err := os.WriteFile(file, []byte("package rig\n\nfunc TestNew(t *testing.T) {}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if d, _ := embedded.discovery(dir, files, nil); d != nil {
+			t.Errorf("Expected the stale embedded table not to be used")
+		}
+	})
+}
+
+func TestEmbed(t *testing.T) {
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
+	origDefaultPkg := defaultPkg
+	defer func() { defaultPkg = origDefaultPkg }()
+	embedded.Lock()
+	origLabels, origMutated := embedded.labels, embedded.mutated
+	embedded.labels, embedded.mutated = nil, false
+	embedded.Unlock()
+	defer func() {
+		embedded.Lock()
+		embedded.labels, embedded.mutated = origLabels, origMutated
+		embedded.Unlock()
+	}()
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// The sources of the test binary are not reachable, like a binary built by go test -c and copied elsewhere.
+	// The manifest of the current directory doesn't belong to the package, it's not applied to the table.
+	rigDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rigDir, ManifestFileName), []byte(`{"TestDesk": {"group": "rig"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rigDir, "go.mod"), []byte("module example.com/desk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(rigDir)
+	os.Args = []string{"theBinDoesntMatter", "-test.v", "-labels", "group=rig"}
+	defaultPkg = "."
+
+	// The table is embedded by the init of the test package, before TestMain mutates the test filter
+	Embed(&EmbeddedLabels{
+		Package: "example.com/rig",
+		Tests:   map[string]TestLabels{"TestRig": {"group": "rig"}, "TestDesk": {"group": "desk"}},
+	})
+	MutateTestFilterByLabels()
+	expected := []string{"theBinDoesntMatter", "-test.v", "-test.run", "^TestRig$"}
+	if !slices.Equal(os.Args, expected) {
+		t.Errorf("Expected %v, got %v", expected, os.Args)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no logs, got:\n%s", logs.String())
+	}
+
+	// The table embedded after the mutation, e.g. by the apply package, isn't used
+	Embed(&EmbeddedLabels{Package: "example.com/rig"})
+	if !strings.Contains(logs.String(), "call MutateTestFilterByLabels from TestMain") {
+		t.Errorf("Expected a warning of the table embedded after the mutation, got:\n%s", logs.String())
+	}
+}
+
+// The test binaries built by go test -c run from a directory without their sources, the run fails if the package
+// has no embedded table.
+func TestEmbedTestBinary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the test binaries is slow")
+	}
+	binDir, runDir := t.TempDir(), t.TempDir()
+	build := func(pkg string) string {
+		bin := filepath.Join(binDir, filepath.Base(pkg)+".test")
+		if out, err := exec.Command("go", "test", "-c", "-o", bin, pkg).CombinedOutput(); err != nil {
+			t.Fatalf("failed to build %s: %v\n%s", pkg, err, out)
+		}
+		return bin
+	}
+
+	t.Run("Embedded labels", func(t *testing.T) {
+		cmd := exec.Command(build("./examples/embedded"), "-test.v", "-labels", "group=rig")
+		cmd.Dir = runDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Expected the run to pass, got %v\n%s", err, out)
+		}
+		for _, want := range []string{"--- PASS: TestEmbeddedRig", "--- PASS: TestEmbeddedRegions"} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("Expected %q in the output:\n%s", want, out)
+			}
+		}
+		if strings.Contains(string(out), "TestEmbeddedDesk") {
+			t.Errorf("Expected TestEmbeddedDesk not to run:\n%s", out)
+		}
+	})
+
+	t.Run("No embedded labels", func(t *testing.T) {
+		cmd := exec.Command(build("./examples/simple"), "-test.v", "-labels", "group=demo")
+		cmd.Dir = runDir
+		out, err := cmd.CombinedOutput()
+		if err == nil || !strings.Contains(string(out), "is not found") {
+			t.Errorf("Expected the run to fail without the source, got %v\n%s", err, out)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+
+	_ "github.com/maxwu/gotest-labels/apply"
+)
+
+// @suite=hotpath
+func TestBenchFixture(t *testing.T) {
+	t.Log("Testing examples.bench.TestBenchFixture")
+}
+
+// @suite=hotpath
+func BenchmarkHotpathJoin(b *testing.B) {
+	for b.Loop() {
+		_ = strings.Join([]string{"a", "b", "c"}, ",")
+	}
+}
+
+// @suite=hotpath
+func BenchmarkHotpathSplit(b *testing.B) {
+	for b.Loop() {
+		_ = strings.Split("a,b,c", ",")
+	}
+}
+
+func BenchmarkColdStart(b *testing.B) {
+	for b.Loop() {
+		_ = strings.Repeat("a", 1024)
+	}
+}
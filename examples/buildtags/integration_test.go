@@ -0,0 +1,10 @@
+//go:build integration
+
+package buildtags
+
+import "testing"
+
+// @group=buildtags @integration
+func TestIntegrationAlpha(t *testing.T) {
+	t.Log("Testing examples.buildtags.TestIntegrationAlpha")
+}
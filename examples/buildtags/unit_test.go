@@ -0,0 +1,12 @@
+package buildtags
+
+import (
+	"testing"
+
+	_ "github.com/maxwu/gotest-labels/apply"
+)
+
+// @group=buildtags
+func TestUnitAlpha(t *testing.T) {
+	t.Log("Testing examples.buildtags.TestUnitAlpha")
+}
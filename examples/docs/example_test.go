@@ -0,0 +1,27 @@
+package docs
+
+import (
+	"fmt"
+	"strings"
+
+	_ "github.com/maxwu/gotest-labels/apply"
+)
+
+// ExampleToUpper shows a fast documentation example.
+func ExampleToUpper() {
+	fmt.Println(strings.ToUpper("gopher"))
+	// Output: GOPHER
+}
+
+// ExampleFields shows a documentation example which is slow in real projects, e.g. loading fixtures.
+// @docs=slow
+func ExampleFields() {
+	fmt.Println(strings.Fields(" a b  c "))
+	// Output: [a b c]
+}
+
+// ExampleRepeat is compiled but not run since it has no output comment.
+// @docs=slow
+func ExampleRepeat() {
+	fmt.Println(strings.Repeat("a", 3))
+}
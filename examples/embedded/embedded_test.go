@@ -0,0 +1,46 @@
+package embedded
+
+//go:generate go run github.com/maxwu/gotest-labels/cmd/gotest-labels-gen
+
+import (
+	"os"
+	"testing"
+
+	gotest_labels "github.com/maxwu/gotest-labels"
+)
+
+// The labels are embedded into the test binary by the generated zz_testlabels_test.go, so the binary built by
+// `go test -c` selects the tests by labels without the source tree.
+// The apply package can't be used here since its init runs before the init of the generated file.
+func TestMain(m *testing.M) {
+	gotest_labels.MutateTestFilterByLabels()
+	os.Exit(m.Run())
+}
+
+// @group=rig
+// @hardware
+func TestEmbeddedRig(t *testing.T) {
+	t.Log("Testing examples.embedded.TestEmbeddedRig")
+}
+
+// @group=desk
+func TestEmbeddedDesk(t *testing.T) {
+	t.Log("Testing examples.embedded.TestEmbeddedDesk")
+}
+
+// @group=rig
+func TestEmbeddedRegions(t *testing.T) {
+	tests := []struct {
+		name string
+	}{
+		// @region=eu
+		{name: "eu"},
+		// @region=us
+		{name: "us"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Log("Testing examples.embedded.TestEmbeddedRegions/" + tt.name)
+		})
+	}
+}
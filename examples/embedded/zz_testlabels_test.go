@@ -0,0 +1,19 @@
+// Code generated by gotest-labels-gen. DO NOT EDIT.
+
+package embedded
+
+import gotest_labels "github.com/maxwu/gotest-labels"
+
+func init() {
+	gotest_labels.Embed(&gotest_labels.EmbeddedLabels{
+		Package: "github.com/maxwu/gotest-labels/examples/embedded",
+		Hash:    "54075c1b44d5e565a25a055098458b3bea389241ae0e218c5cca911128c8dc96",
+		Tests: map[string]gotest_labels.TestLabels{
+			"TestEmbeddedDesk":       {"group": "desk"},
+			"TestEmbeddedRegions":    {"group": "rig"},
+			"TestEmbeddedRegions/eu": {"group": "rig", "region": "eu"},
+			"TestEmbeddedRegions/us": {"group": "rig", "region": "us"},
+			"TestEmbeddedRig":        {"group": "rig", "hardware": "true"},
+		},
+	})
+}
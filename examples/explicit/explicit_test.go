@@ -19,7 +19,9 @@ func TestExplicitBeta(t *testing.T) {
 	t.Log("Testing examples.simple.TestSimpleBeta")
 }
 
-// @group=demo
+// TestExplicitGamma is labeled in the Go directive form.
+//
+//gotest:label group=demo
 func TestExplicitGamma(t *testing.T) {
 	t.Log("Testing examples.simple.TestSimpleGamma")
 }
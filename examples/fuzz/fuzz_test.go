@@ -0,0 +1,38 @@
+package fuzz
+
+import (
+	"strconv"
+	"testing"
+
+	_ "github.com/maxwu/gotest-labels/apply"
+)
+
+// @fuzz-tier=critical
+func FuzzParseInt(f *testing.F) {
+	f.Add("42")
+	f.Fuzz(func(t *testing.T, s string) {
+		if n, err := strconv.Atoi(s); err == nil && strconv.Itoa(n) != s && s[0] != '+' && s[0] != '0' && s[0] != '-' {
+			t.Errorf("Atoi(%q) = %d does not round trip", s, n)
+		}
+	})
+}
+
+// @fuzz-tier=critical
+func FuzzQuote(f *testing.F) {
+	f.Add("hello")
+	f.Fuzz(func(t *testing.T, s string) {
+		if _, err := strconv.Unquote(strconv.Quote(s)); err != nil {
+			t.Errorf("Unquote(Quote(%q)) failed: %v", s, err)
+		}
+	})
+}
+
+// @fuzz-tier=extended
+func FuzzFormatBool(f *testing.F) {
+	f.Add(true)
+	f.Fuzz(func(t *testing.T, b bool) {
+		if strconv.FormatBool(b) == "" {
+			t.Errorf("FormatBool(%v) is empty", b)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/maxwu/gotest-labels"
+)
+
+// The labels are registered in init, which runs before TestMain calls MutateTestFilterByLabels.
+// The apply package can't be used here since its init runs before the init of this package.
+func init() {
+	for _, name := range []string{"TestRegisteredAlpha", "TestRegisteredGamma"} {
+		gotest_labels.Register(name, gotest_labels.TestLabels{"group": "demo"})
+	}
+}
+
+func TestMain(m *testing.M) {
+	tests := gotest_labels.MutateTestFilterByLabels()
+	fmt.Printf("Filtered tests: %v\n", tests)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestRegisteredAlpha(t *testing.T) {
+	t.Log("Testing examples.registry.TestRegisteredAlpha")
+}
+
+func TestRegisteredBeta(t *testing.T) {
+	t.Log("Testing examples.registry.TestRegisteredBeta")
+}
+
+func TestRegisteredGamma(t *testing.T) {
+	t.Log("Testing examples.registry.TestRegisteredGamma")
+}
@@ -0,0 +1,46 @@
+package subtests
+
+import (
+	"testing"
+
+	gotest_labels "github.com/maxwu/gotest-labels"
+	_ "github.com/maxwu/gotest-labels/apply"
+)
+
+// @team=platform
+func TestRegions(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels gotest_labels.TestLabels
+	}{
+		{name: "eu region"}, // @region=eu
+		{name: "us-region", labels: gotest_labels.TestLabels{"region": "us"}},
+		{name: "ap-region"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Log("Testing examples.subtests.TestRegions/" + tt.name)
+		})
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	// @region=eu
+	t.Run("eu-health", func(t *testing.T) {
+		t.Log("Testing examples.subtests.TestEndpoints/eu-health")
+	})
+
+	t.Run("generic", func(t *testing.T) {
+		t.Log("Testing examples.subtests.TestEndpoints/generic")
+	})
+}
+
+// The subtests are labeled at runtime, this test always runs and gotest_labels.Run skips the unmatched subtests.
+func TestRuntimeRegions(t *testing.T) {
+	for _, region := range []string{"eu", "us"} {
+		gotest_labels.Run(t, region+"-runtime", gotest_labels.TestLabels{"region": region}, func(t *testing.T) {
+			t.Log("Testing examples.subtests.TestRuntimeRegions/" + region + "-runtime")
+		})
+	}
+}
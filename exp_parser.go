@@ -199,7 +199,15 @@ func Evaluate(node Node, labels TestLabels) bool {
 // The entry point for parsing label expressions
 // It takes a string input and returns an AST representation of the expression
 // or an error if the input is invalid.
+// It only checks the syntax, ParseLabelExpWithSchema validates the keys and values against a label schema as well,
+// which MutateTestFilterByLabels does with the schema of the module.
 func ParseLabelExp(input string) (Node, error) {
+	return ParseLabelExpWithSchema(input, nil)
+}
+
+// Parse the label expression like ParseLabelExp, it also returns an error if the expression has a key or value
+// not allowed by the label schema. A nil schema allows any labels.
+func ParseLabelExpWithSchema(input string, schema *LabelSchema) (Node, error) {
 	tokens := tokenize(input)
 	if len(tokens) == 0 {
 		return nil, fmt.Errorf("empty input")
@@ -212,5 +220,8 @@ func ParseLabelExp(input string) (Node, error) {
 	if pos < len(tokens) {
 		return nil, fmt.Errorf("unexpected token %s at position %d", tokens[pos], pos)
 	}
+	if err := schema.validateExp(node); err != nil {
+		return nil, err
+	}
 	return node, nil
 }
@@ -1,15 +1,38 @@
+// Package gotest_labels selects the go tests to run by the labels in their comments, e.g. `go test -labels group=demo`,
+// by mutating the test filter flags in os.Args before the testing package parses them.
+//
+// The package variables configuring it, e.g. LabelMarker, DeriveLabels, RecursiveDiscovery and SourceDir, are read
+// by MutateTestFilterByLabels, so they only take effect if it's called from TestMain after setting them. The apply
+// package calls it from its init function, which runs before any code of the test package, so only the TEST_LABELS_*
+// env variables configure it.
 package gotest_labels
 
 import (
+	"errors"
+	"fmt"
+	"go/token"
 	"log"
 	"maps"
 	"os"
+	"regexp"
 	"slices"
 	"strings"
 )
 
 type TestLabels map[string]string
 
+// The tests selected by labels in the current test binary
+type testSelection struct {
+	tests        map[string]TestLabels // The selected tests and subtests with their labels
+	excluded     map[string]TestLabels // The labeled subtests to skip since they're not selected but their parents are
+	suiteMethods map[string]bool       // The testify suite methods discovered, e.g. `TestMySuite/TestCreate`
+	diagnostics  []Diagnostic          // The syntax problems of labels in the comments and the schema violations
+	candidates   map[string]TestLabels // The tests and subtests matched by the user's -run and -skip regardless of labels
+	discovered   bool                  // Whether the tests are discovered, the candidates are unknown if it failed
+	listMode     bool                  // Whether it's in listing mode
+	err          error                 // The error of the tests which can't be discovered, e.g. a malformed manifest
+}
+
 // The actually exposed entrypoint to mutate the test functions by labels
 // It can be called in the TestMain function of the test package.
 // If the test command is running tests with wildcards for sub packages, either set the labels
@@ -19,7 +42,23 @@ type TestLabels map[string]string
 // the test costs or support the test operation/observability/report features.
 func MutateTestFilterByLabels() map[string]TestLabels {
 	args := ParseOSArgs()
-	tests, listMode := getTestFuncsByLabels(args)
+	selection := getTestFuncsByLabels(args)
+	tests := selection.tests
+	if err := reportDiagnostics(selection.diagnostics, args.strict); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	var notFound *sourceNotFoundError
+	if selection.err != nil && args.labelsEnabled() {
+		// Selecting no tests, or the tests of unrelated sources, would silently pass the test run
+		log.Fatalf("Error: %v", selection.err)
+	} else if selection.err != nil && !errors.As(selection.err, &notFound) {
+		log.Printf("Error: %v", selection.err)
+	}
+
+	// The invalid patterns or labels filter given along with the labels fail the run instead of running all tests
+	if args.err != nil && args.labels != "" {
+		log.Fatalf("Error: %v", args.err)
+	}
 
 	// If the labels are not enabled, return the original tests without mutating the os.Args.
 	// The results are useful to estimate the test time and costs.
@@ -28,55 +67,366 @@ func MutateTestFilterByLabels() map[string]TestLabels {
 		return tests
 	}
 
-	// If the labels are enabled, mutate the os.Args to run the selected tests.
-	pattern := buildTestNamePattern(tests)
-	if listMode {
-		os.Args = append(os.Args, "-test.list", pattern)
-	} else {
-		os.Args = append(os.Args, "-test.run", pattern)
+	// If the labels are enabled, mutate the os.Args to run the selected tests. The flags given by the user are
+	// rewritten in place with the patterns intersecting them.
+	if selection.listMode {
+		os.Args = setFlag(os.Args, "-test.list", buildTestNamePattern(getTopLevelTests(tests)))
+		return tests
+	}
+
+	// Benchmarks are selected by -test.bench, which is only rewritten if the user asked to run benchmarks.
+	runTests, benchmarks := partitionBenchmarks(tests)
+	if args.fuzzPattern != nil {
+		// The fuzz target may be selected by -test.fuzz only, it shall not be added to -test.run.
+		fuzzTargets := filterTestFuncsByPattern(getFuzzTargets(runTests), args.fuzzPattern)
+		runTests = filterTestFuncsByPattern(runTests, args.runPattern)
+		pattern, err := buildFuzzPattern(fuzzTargets)
+		if err != nil {
+			log.Printf("Error selecting fuzz target: %v", err)
+		}
+		os.Args = setFlag(os.Args, "-test.fuzz", pattern)
+	}
+	runPattern, skipPattern := buildRunSkipPatterns(selection, runTests, args)
+	if runPattern != "" {
+		os.Args = setFlag(os.Args, "-test.run", runPattern)
+	}
+	if skipPattern != "" {
+		os.Args = setFlag(os.Args, "-test.skip", skipPattern)
+	}
+	if args.benchPattern != nil {
+		os.Args = setFlag(os.Args, "-test.bench", buildRunPattern(benchmarks, args.benchPattern))
+	}
+	if len(selection.suiteMethods) > 0 {
+		os.Args = setFlag(os.Args, "-testify.m", buildSuiteMethodPattern(selection, args.testifyRegex))
 	}
 
 	return tests
 }
 
+// Build the -test.run and -test.skip patterns to run the selected tests, either by including the selected tests in
+// -test.run or by excluding the unselected tests in -test.skip, whichever is shorter, e.g. `!flaky` excludes a few
+// tests out of thousands. An empty pattern leaves the user's flag as it is.
+//
+// The exclusion keeps the user's -test.run, so it only applies if each top-level test is selected or unselected as a
+// whole. A test with only some of its subtests selected can't be expressed by skipping, since its unlabeled subtests
+// are not discovered. It doesn't apply if the discovery failed either, since the tests to skip are unknown.
+func buildRunSkipPatterns(selection *testSelection, runTests map[string]TestLabels, args *cliArgs) (string, string) {
+	runPattern := buildRunPattern(runTests, args.runPattern)
+	skipPattern := buildSkipPattern(selection.excluded, args.skipPattern)
+	if !selection.discovered {
+		return runPattern, skipPattern
+	}
+
+	unselected, ok := getUnselectedTests(selection.candidates, runTests)
+	if !ok {
+		return runPattern, skipPattern
+	}
+	excluded := maps.Clone(selection.excluded)
+	maps.Copy(excluded, unselected)
+	excludePattern := buildSkipPattern(excluded, args.skipPattern)
+	if len(args.runPattern.String())+len(excludePattern) < len(runPattern)+len(skipPattern) {
+		return "", excludePattern
+	}
+	return runPattern, skipPattern
+}
+
+// Build the -test.skip pattern of the excluded tests along with the user's skip pattern, it's empty if there's no
+// test to exclude so the user's pattern is left as it is.
+func buildSkipPattern(excluded map[string]TestLabels, user *namePattern) string {
+	if len(excluded) == 0 {
+		return ""
+	}
+	// The labeled subtests not selected under a selected test are skipped, along with the user's skip pattern
+	pattern := buildTestNamePattern(excluded)
+	if user != nil {
+		pattern = user.String() + "|" + pattern
+	}
+	return pattern
+}
+
+// Get the top-level candidate tests which are not selected, neither any of their subtests. It returns false if a test
+// is only selected by some of its subtests.
+func getUnselectedTests(candidates map[string]TestLabels, selected map[string]TestLabels) (map[string]TestLabels, bool) {
+	for name := range selected {
+		if strings.Contains(name, "/") && !hasSelectedAncestor(name, selected) {
+			return nil, false
+		}
+	}
+	unselected := make(map[string]TestLabels)
+	for name, labels := range candidates {
+		if _, ok := selected[name]; !ok && !strings.Contains(name, "/") {
+			unselected[name] = labels
+		}
+	}
+	return unselected, true
+}
+
+// Print the label diagnostics, in strict mode it returns an error if there's any diagnostic so that the
+// mistakes are fixed when the labels are written rather than when a test silently doesn't run.
+func reportDiagnostics(diagnostics []Diagnostic, strict bool) error {
+	for _, d := range diagnostics {
+		log.Printf("Warning: %s", d)
+	}
+	if strict && len(diagnostics) > 0 {
+		return fmt.Errorf("found %d malformed labels in strict mode", len(diagnostics))
+	}
+	return nil
+}
+
+// Build the go test regex pattern to select the given tests. The subtests are selected with slash-separated
+// patterns like `^TestX$/^eu-region$`, which go test matches level by level. The patterns of different parent
+// tests are joined as top-level alternations, e.g. `^(TestA|TestB)$|^TestX$/^(eu|us)$`.
 func buildTestNamePattern(tests map[string]TestLabels) string {
-	testNames := slices.Collect(maps.Keys(tests))
-	if len(testNames) == 0 {
+	namesByParent := map[string][]string{}
+	for name := range tests {
+		if hasSelectedAncestor(name, tests) {
+			continue
+		}
+		parent, subName := "", name
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			parent, subName = name[:i], name[i+1:]
+		}
+		namesByParent[parent] = append(namesByParent[parent], subName)
+	}
+	if len(namesByParent) == 0 {
 		return "^$"
 	}
-	slices.Sort(testNames)
-	if len(testNames) == 1 {
-		return "^" + testNames[0] + "$"
+
+	var patterns []string
+	for _, parent := range slices.Sorted(maps.Keys(namesByParent)) {
+		pattern := buildAlternationPattern(namesByParent[parent])
+		if parent != "" {
+			pattern = buildLevelsPattern(parent) + "/" + pattern
+		}
+		patterns = append(patterns, pattern)
+	}
+	return strings.Join(patterns, "|")
+}
+
+// Build the pattern matching each level of the test name exactly, e.g. `^TestX$/^eu-region$`
+func buildLevelsPattern(name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = "^" + regexp.QuoteMeta(elem) + "$"
+	}
+	return strings.Join(elems, "/")
+}
+
+// Build the -test.run or -test.bench pattern of the selected tests which intersects the user's pattern. The tests
+// the user's pattern only matches partially keep the subtest levels of it, e.g. `^TestA$/case1` of the selected
+// TestA with `-run TestA/case1`, so they still run the subtests the user selected.
+func buildRunPattern(tests map[string]TestLabels, user *namePattern) string {
+	whole := make(map[string]TestLabels)
+	var partial []string
+	for name, labels := range tests {
+		if hasSelectedAncestor(name, tests) {
+			continue
+		}
+		suffixes := user.subtestPatterns(name)
+		if suffixes == nil {
+			whole[name] = labels
+			continue
+		}
+		for _, suffix := range suffixes {
+			partial = append(partial, buildLevelsPattern(name)+"/"+suffix)
+		}
+	}
+	if len(partial) == 0 {
+		return buildTestNamePattern(whole)
+	}
+	slices.Sort(partial)
+	if len(whole) == 0 {
+		return strings.Join(partial, "|")
+	}
+	return buildTestNamePattern(whole) + "|" + strings.Join(partial, "|")
+}
+
+func buildAlternationPattern(names []string) string {
+	return "^" + buildTriePattern(names) + "$"
+}
+
+// Check whether any parent test of the given subtest name is in the tests, e.g. `TestX` for `TestX/eu-region`
+func hasSelectedAncestor(name string, tests map[string]TestLabels) bool {
+	for i := range len(name) {
+		if name[i] == '/' {
+			if _, ok := tests[name[:i]]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the top-level tests of the given tests and subtests, which are the names -test.list matches against
+func getTopLevelTests(tests map[string]TestLabels) map[string]TestLabels {
+	topLevel := make(map[string]TestLabels)
+	for name, labels := range tests {
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i]
+			labels = tests[name]
+		}
+		if _, ok := topLevel[name]; !ok || labels != nil {
+			topLevel[name] = labels
+		}
+	}
+	return topLevel
+}
+
+// Get the labeled subtests which are not selected while one of their parent tests is selected as a whole,
+// so they have to be skipped explicitly.
+func getExcludedSubtests(all map[string]TestLabels, selected map[string]TestLabels) map[string]TestLabels {
+	excluded := make(map[string]TestLabels)
+	for name, labels := range all {
+		if _, ok := selected[name]; !ok && hasSelectedAncestor(name, selected) {
+			excluded[name] = labels
+		}
 	}
-	return "^(" + strings.Join(testNames, "|") + ")$"
+	return excluded
+}
+
+// Build the -testify.m pattern of the suite methods to run. testify matches it against the method names of
+// all suites in the test binary, so it's the union of the methods to run in the selected suites, which are
+// also filtered by the user's -testify.m regex.
+func buildSuiteMethodPattern(selection *testSelection, regex *regexp.Regexp) string {
+	methods := make(map[string]TestLabels)
+	for name := range selection.suiteMethods {
+		if _, excluded := selection.excluded[name]; excluded {
+			continue
+		}
+		if _, ok := selection.tests[name]; !ok && !hasSelectedAncestor(name, selection.tests) {
+			continue
+		}
+		method := name[strings.LastIndex(name, "/")+1:]
+		if regex == nil || regex.MatchString(method) {
+			methods[method] = selection.tests[name]
+		}
+	}
+	return buildTestNamePattern(methods)
+}
+
+// Build the -test.fuzz pattern, go test requires it to match exactly one fuzz target when fuzzing.
+// If the labels select more targets, the pattern of all of them is still returned with an error, so the
+// go test run fails with the list of targets instead of fuzzing an unexpected one.
+func buildFuzzPattern(targets map[string]TestLabels) (string, error) {
+	pattern := buildTestNamePattern(targets)
+	if len(targets) > 1 {
+		names := slices.Sorted(maps.Keys(targets))
+		return pattern, fmt.Errorf("labels select %d fuzz targets %v but -test.fuzz requires exactly one, "+
+			"list them with -test.list and fuzz them one by one", len(names), names)
+	}
+	return pattern, nil
 }
 
 // The internal function to get the selected test functions by labels and whether it's in listing mode
-// It returns a map of function names to their labels
-func getTestFuncsByLabels(args *cliArgs) (map[string]TestLabels, bool) {
+// It returns the selection with a map of function names to their labels
+func getTestFuncsByLabels(args *cliArgs) *testSelection {
+	selection := &testSelection{
+		suiteMethods: map[string]bool{},
+		listMode:     args.listMode,
+	}
 
-	allPkgs, err := getPackages()
+	embedded := getEmbeddedLabels()
+	var filesByDir map[string][]string
+	err := args.sourceErr
+	if err == nil {
+		filesByDir, err = findTestFiles(args.sourceDir)
+	}
+	pkgDir := args.sourceDir
+	if embedded != nil && (err != nil || len(filesByDir) == 0) {
+		// The test binary runs without its source tree, the tests are only known from the embedded table
+		filesByDir, pkgDir, err = map[string][]string{".": nil}, ".", nil
+	}
+	var notFound *sourceNotFoundError
+	if errors.As(err, &notFound) {
+		selection.err = err
+		return selection
+	}
 	if err != nil {
-		log.Printf("Error resolving packages: %#v", err)
-		return nil, args.listMode
+		selection.err = fmt.Errorf("failed to resolve packages: %v", err)
+		return selection
 	}
 
 	allTestFuncs := make(map[string]TestLabels)
+	allPositions := make(map[string]token.Position)
+	runtimeTests := make(map[string]bool)
 
-	for _, pkg := range allPkgs {
-		files := getTestFiles(pkg)
-		funcs, err := FindTestFuncs(files, args.labelsAST)
+	for _, dir := range slices.Sorted(maps.Keys(filesByDir)) {
+		d, err := embedded.discovery(dir, filesByDir[dir], args.buildTags)
 		if err != nil {
-			log.Printf("Error parsing tests %s: : %#v", pkg.Name, err)
-			return nil, args.listMode
+			selection.err = err
+			return selection
+		}
+		if d == nil {
+			if d, err = discoverTestFuncs(filesByDir[dir]); err != nil {
+				selection.err = fmt.Errorf("failed to parse tests in %s: %v", dir, err)
+				return selection
+			}
 		}
-		// I haven't considered the duplicated test function names since it's the package level
-		// filter for each MutateTestFilterByLabels call.
-		for k, v := range funcs {
-			allTestFuncs[k] = v
+		// Without the sources, the manifest of the embedded table is already applied and the manifest in the
+		// current directory is unrelated to the package
+		sources := getDirLabelSources(LabelSources, isSameDir(dir, pkgDir), filesByDir[dir] != nil)
+		if err := applyLabelSources(sources, dir, d.funcs); err != nil {
+			selection.err = fmt.Errorf("failed to load labels in %s: %v", dir, err)
+			return selection
 		}
+
+		// The test names only collide if the packages are discovered recursively, the tests of the same name
+		// in different packages are selected or skipped together by go test.
+		for _, name := range slices.Sorted(maps.Keys(d.funcs)) {
+			if _, ok := allTestFuncs[name]; ok && !strings.Contains(name, "/") {
+				log.Printf("Warning: test %s in %s collides with a test of the same name in another package", name, dir)
+			}
+		}
+		maps.Copy(allTestFuncs, d.funcs)
+		maps.Copy(allPositions, d.positions)
+		maps.Copy(runtimeTests, d.runtimeTests)
+		maps.Copy(selection.suiteMethods, d.suiteMethods)
+		selection.diagnostics = append(selection.diagnostics, d.diagnostics...)
 	}
+	selection.diagnostics = append(selection.diagnostics, args.schema.validateTests(allTestFuncs, allPositions)...)
+	selection.discovered = true
+	setRuntimeLabels(args, allTestFuncs)
 
-	matchedFuncs := filterTestFuncs(allTestFuncs, args.runRegex)
-	return matchedFuncs, args.listMode
+	selectedFuncs := filterTestFuncsByLabels(allTestFuncs, args.labelsAST)
+	// The tests with subtests labeled at runtime shall run, so Run can select their subtests.
+	for name := range runtimeTests {
+		selectedFuncs[name] = allTestFuncs[name]
+	}
+	if args.listMode {
+		selection.tests = filterTestFuncs(selectedFuncs, args.listRegex)
+		return selection
+	}
+
+	// The tests to run regardless of labels, the tests not selected by labels may be skipped instead
+	allRunFuncs, _ := partitionBenchmarks(allTestFuncs)
+	selection.candidates = filterTestFuncsByPattern(allRunFuncs, args.runPattern)
+	for name := range selection.candidates {
+		if args.skipPattern.skips(name) {
+			delete(selection.candidates, name)
+		}
+	}
+
+	// The benchmarks only run if -test.bench is given and they are matched by its pattern instead of -test.run.
+	runFuncs, benchmarks := partitionBenchmarks(selectedFuncs)
+	matchedFuncs := filterTestFuncsByPattern(runFuncs, args.runPattern)
+	if args.benchPattern != nil {
+		maps.Copy(matchedFuncs, filterTestFuncsByPattern(benchmarks, args.benchPattern))
+	}
+	if args.fuzzPattern != nil {
+		maps.Copy(matchedFuncs, filterTestFuncsByPattern(getFuzzTargets(runFuncs), args.fuzzPattern))
+	}
+	// The tests skipped by the user's -test.skip don't run, neither their subtests
+	for name := range matchedFuncs {
+		if args.skipPattern.skips(name) {
+			delete(matchedFuncs, name)
+		}
+	}
+	selection.tests = matchedFuncs
+	selection.excluded = getExcludedSubtests(allTestFuncs, matchedFuncs)
+	for name := range selection.excluded {
+		if args.skipPattern.skips(name) {
+			delete(selection.excluded, name)
+		}
+	}
+	return selection
 }
@@ -1,7 +1,13 @@
 package gotest_labels
 
 import (
+	"fmt"
+	"go/token"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -36,19 +42,9 @@ func TestMutateTestFilterByLabels(t *testing.T) {
 			t.Fail()
 		}
 
-		if len(os.Args) != 5 {
-			t.Errorf("Expected 5 args after mutation, got %#v", os.Args)
-			t.Fail()
-		}
-
-		if os.Args[3] != "-test.list" {
-			t.Errorf("Expected test.list, got %v", os.Args[3])
-			t.Fail()
-		}
-
-		if os.Args[4] != "^(TestSimpleAlpha|TestSimpleGamma)$" {
-			t.Errorf("Expected ^(TestSimpleAlpha|TestSimpleGamma)$, got %v", os.Args[4])
-			t.Fail()
+		expected := []string{"theBinDoesntMatter", "-test.v", "-test.list=^TestSimple(Alpha|Gamma)$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
 		}
 	})
 
@@ -75,19 +71,10 @@ func TestMutateTestFilterByLabels(t *testing.T) {
 			t.Fail()
 		}
 
-		if len(os.Args) != 5 {
-			t.Errorf("Expected 5 args after mutation, got %#v", os.Args)
-			t.Fail()
-		}
-
-		if os.Args[3] != "-test.run" {
-			t.Errorf("Expected test.list, got %v", os.Args[3])
-			t.Fail()
-		}
-
-		if os.Args[4] != "^TestSimpleAlpha$" {
-			t.Errorf("Expected ^TestSimpleAlpha$, got %v", os.Args[4])
-			t.Fail()
+		// The user's -test.run only matches the selected test, so it's kept as it is
+		expected := []string{"theBinDoesntMatter", "-test.v", "-test.run=Alpha"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
 		}
 	})
 
@@ -156,4 +143,407 @@ func TestMutateTestFilterByLabels(t *testing.T) {
 			t.Fail()
 		}
 	})
+
+	t.Run("Labels from sidecar manifest", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-test.v", "-labels", "group=demo"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/manifest"
+
+		tests := MutateTestFilterByLabels()
+
+		if len(tests) != 2 {
+			t.Errorf("Expected 2 tests, got %v", tests)
+		}
+
+		if tests["TestGeneratedAlpha"]["regression"] != DefaultLabelValue {
+			t.Errorf("Expected TestGeneratedAlpha with regression label, got %v", tests["TestGeneratedAlpha"])
+		}
+
+		// Skipping the unselected test is shorter than selecting the others
+		expected := []string{"theBinDoesntMatter", "-test.v", "-test.skip", "^TestGeneratedBeta$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+
+	t.Run("Labels enabled in go test bench", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-test.run=^$", "-test.bench=Join|Cold", "-labels", "suite=hotpath"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/bench"
+
+		tests := MutateTestFilterByLabels()
+
+		if len(tests) != 1 || tests["BenchmarkHotpathJoin"] == nil {
+			t.Errorf("Expected BenchmarkHotpathJoin only, got %v", tests)
+		}
+
+		expected := []string{"theBinDoesntMatter", "-test.run=^$", "-test.bench=^BenchmarkHotpathJoin$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+
+	t.Run("Benchmarks are not selected without -test.bench", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-labels", "suite=hotpath"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/bench"
+
+		tests := MutateTestFilterByLabels()
+
+		if len(tests) != 1 || tests["TestBenchFixture"] == nil {
+			t.Errorf("Expected TestBenchFixture only, got %v", tests)
+		}
+
+		// All the tests are selected, the benchmarks don't run without -test.bench
+		expected := []string{"theBinDoesntMatter"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+
+	t.Run("Labels enabled in go test fuzz", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-test.run=^$", "-test.fuzz=Int", "-labels", "fuzz-tier=critical"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/fuzz"
+
+		tests := MutateTestFilterByLabels()
+
+		if len(tests) != 1 || tests["FuzzParseInt"] == nil {
+			t.Errorf("Expected FuzzParseInt only, got %v", tests)
+		}
+
+		expected := []string{"theBinDoesntMatter", "-test.run=^$", "-test.fuzz=^FuzzParseInt$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+
+	t.Run("Seed corpus runs of labeled fuzz targets", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-labels", "fuzz-tier=critical"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/fuzz"
+
+		tests := MutateTestFilterByLabels()
+
+		if len(tests) != 2 {
+			t.Errorf("Expected 2 fuzz targets, got %v", tests)
+		}
+
+		expected := []string{"theBinDoesntMatter", "-test.skip", "^FuzzFormatBool$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+
+	t.Run("Labels on subtests", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-labels", "region=eu"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/subtests"
+
+		tests := MutateTestFilterByLabels()
+
+		if len(tests) != 3 || tests["TestRegions/eu_region"]["team"] != "platform" {
+			t.Errorf("Expected 2 subtests with inherited labels and 1 test with runtime labels, got %v", tests)
+		}
+
+		expected := []string{"theBinDoesntMatter", "-test.run",
+			"^TestRuntimeRegions$|^TestEndpoints$/^eu-health$|^TestRegions$/^eu_region$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+
+	t.Run("Labeled subtests excluded from selected tests", func(t *testing.T) {
+		origArgs := os.Args
+		defer func() { os.Args = origArgs }()
+		os.Args = []string{"theBinDoesntMatter", "-labels", "!region=us"}
+		origDefaultPkg := defaultPkg
+		defer func() { defaultPkg = origDefaultPkg }()
+		defaultPkg = "./examples/subtests"
+
+		_ = MutateTestFilterByLabels()
+
+		expected := []string{"theBinDoesntMatter", "-test.skip", "^TestRegions$/^us-region$"}
+		if !slices.Equal(os.Args, expected) {
+			t.Errorf("Expected %v, got %v", expected, os.Args)
+		}
+	})
+}
+
+func TestBuildTestNamePattern(t *testing.T) {
+	tests := map[string]struct {
+		names []string
+		want  string
+	}{
+		"no test": {
+			names: nil,
+			want:  "^$",
+		},
+		"one test": {
+			names: []string{"TestA"},
+			want:  "^TestA$",
+		},
+		"tests": {
+			names: []string{"TestB", "TestA"},
+			want:  "^Test[AB]$",
+		},
+		"subtests": {
+			names: []string{"TestA", "TestX/eu", "TestX/us", "TestY/a/b"},
+			want:  "^TestA$|^TestX$/^(eu|us)$|^TestY$/^a$/^b$",
+		},
+		"subtests of selected tests": {
+			names: []string{"TestA", "TestA/eu", "TestX/eu", "TestX/eu/gold"},
+			want:  "^TestA$|^TestX$/^eu$",
+		},
+		"subtest names are quoted": {
+			names: []string{"TestX/a.b(c)|d"},
+			want:  `^TestX$/^a\.b\(c\)\|d$`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			funcs := map[string]TestLabels{}
+			for _, name := range tt.names {
+				funcs[name] = TestLabels{}
+			}
+			if got := buildTestNamePattern(funcs); got != tt.want {
+				t.Errorf("buildTestNamePattern(%v) = %v, want %v", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSuiteMethodPattern(t *testing.T) {
+	suiteMethods := map[string]bool{
+		"TestAccountSuite/TestCreate": true,
+		"TestAccountSuite/TestDelete": true,
+		"TestAccountSuite/TestList":   true,
+		"TestOrderSuite/TestCreate":   true,
+		"TestOrderSuite/TestCancel":   true,
+	}
+
+	tests := map[string]struct {
+		selection *testSelection
+		regex     *regexp.Regexp
+		want      string
+	}{
+		"selected methods": {
+			selection: &testSelection{
+				tests: map[string]TestLabels{
+					"TestAccountSuite/TestCreate": {},
+					"TestOrderSuite/TestCreate":   {},
+				},
+			},
+			want: "^TestCreate$",
+		},
+		"selected suite with excluded method": {
+			selection: &testSelection{
+				tests:    map[string]TestLabels{"TestAccountSuite": {}},
+				excluded: map[string]TestLabels{"TestAccountSuite/TestDelete": {}},
+			},
+			want: "^Test(Create|List)$",
+		},
+		"filtered by user regex": {
+			selection: &testSelection{
+				tests: map[string]TestLabels{"TestAccountSuite": {}, "TestOrderSuite": {}},
+			},
+			regex: regexp.MustCompile("Create|Cancel"),
+			want:  "^TestC(ancel|reate)$",
+		},
+		"no suite selected": {
+			selection: &testSelection{tests: map[string]TestLabels{"TestOther": {}}},
+			want:      "^$",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.selection.suiteMethods = suiteMethods
+			if got := buildSuiteMethodPattern(tt.selection, tt.regex); got != tt.want {
+				t.Errorf("buildSuiteMethodPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportDiagnostics(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Pos: token.Position{Filename: "a_test.go", Line: 3, Column: 4}, Message: "empty label key"},
+	}
+
+	if err := reportDiagnostics(diagnostics, false); err != nil {
+		t.Errorf("reportDiagnostics() error = %v, want nil", err)
+	}
+	if err := reportDiagnostics(nil, true); err != nil {
+		t.Errorf("reportDiagnostics(nil, true) error = %v, want nil", err)
+	}
+	if err := reportDiagnostics(diagnostics, true); err == nil {
+		t.Errorf("reportDiagnostics() in strict mode error = nil, want an error")
+	}
+}
+
+func TestBuildRunSkipPatterns(t *testing.T) {
+	names := func(format string, n int) []string {
+		var names []string
+		for i := range n {
+			names = append(names, fmt.Sprintf(format, i))
+		}
+		return names
+	}
+	tests := []struct {
+		name       string
+		candidates []string
+		selected   []string
+		excluded   []string
+		userRun    string
+		userSkip   string
+		wantRun    string
+		wantSkip   string
+	}{
+		{
+			name:       "few excluded",
+			candidates: names("TestCase%02d", 20),
+			selected:   slices.Delete(names("TestCase%02d", 20), 3, 6),
+			wantSkip:   "^TestCase0[3-5]$",
+		},
+		{
+			name:       "few selected",
+			candidates: names("TestCase%02d", 20),
+			selected:   []string{"TestCase03", "TestCase04"},
+			wantRun:    "^TestCase0[34]$",
+		},
+		{
+			name: "excluded along with the user's patterns",
+			// The candidates and the selected tests don't include the tests skipped by the user
+			candidates: names("TestCase%02d", 19),
+			selected:   slices.Delete(names("TestCase%02d", 19), 3, 4),
+			excluded:   []string{"TestCase05/eu"},
+			userRun:    "TestCase",
+			userSkip:   "TestCase19",
+			wantSkip:   "TestCase19|^TestCase03$|^TestCase05$/^eu$",
+		},
+		{
+			name:       "subtests selected partially",
+			candidates: append(names("TestCase%02d", 20), "TestCase00/eu"),
+			selected:   append(slices.Delete(names("TestCase%02d", 20), 0, 1), "TestCase00/eu"),
+			wantRun:    "^TestCase(0[1-9]|1[0-9])$|^TestCase00$/^eu$",
+		},
+		{
+			name:       "nothing selected",
+			candidates: names("TestCase%02d", 20),
+			wantRun:    "^$",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toMap := func(names []string) map[string]TestLabels {
+				m := map[string]TestLabels{}
+				for _, name := range names {
+					m[name] = TestLabels{}
+				}
+				return m
+			}
+			args := parseArgs([]string{"bin", "-test.run=" + tt.userRun, "-test.skip=" + tt.userSkip})
+			selection := &testSelection{candidates: toMap(tt.candidates), excluded: toMap(tt.excluded), discovered: true}
+			selected := toMap(tt.selected)
+
+			run, skip := buildRunSkipPatterns(selection, selected, args)
+			if run != tt.wantRun || skip != tt.wantSkip {
+				t.Errorf("buildRunSkipPatterns() = %q, %q, want %q, %q", run, skip, tt.wantRun, tt.wantSkip)
+			}
+
+			// The same top-level tests run by either pattern
+			if run == "" {
+				run = tt.userRun
+			}
+			if skip == "" {
+				skip = tt.userSkip
+			}
+			runPattern, _ := compileNamePattern("test.run", run)
+			skipPattern, _ := compileNamePattern("test.skip", skip)
+			for _, name := range tt.candidates {
+				if strings.Contains(name, "/") {
+					continue
+				}
+				ok, _ := runPattern.match(name)
+				runs := ok && !skipPattern.skips(name)
+				_, isSelected := selected[name]
+				if runs != (isSelected || hasSelectedDescendant(name, selected)) {
+					t.Errorf("%s runs = %v, selected = %v", name, runs, isSelected)
+				}
+			}
+		})
+	}
+}
+
+func hasSelectedDescendant(name string, selected map[string]TestLabels) bool {
+	for other := range selected {
+		if strings.HasPrefix(other, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// The failed discovery, e.g. of a malformed manifest, fails the run with the labels enabled instead of running no tests.
+// The tests to skip are unknown either, so the unselected tests aren't skipped instead of the selected ones run.
+func TestFailedDiscovery(t *testing.T) {
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
+	origDefaultPkg := defaultPkg
+	defer func() { defaultPkg = origDefaultPkg }()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":         "module example.com/broken\n",
+		"broken_test.go": "package broken\n\nimport \"testing\"\n\n// @group=demo\nfunc TestA(t *testing.T) {}\n\nfunc TestB(t *testing.T) {}\n",
+		ManifestFileName: `{"TestB": "oops"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	defaultPkg = "."
+
+	args := parseArgs([]string{"theBinDoesntMatter", "-test.v", "-labels", "group=demo"})
+	selection := getTestFuncsByLabels(args)
+	if selection.err == nil || !strings.Contains(selection.err.Error(), ManifestFileName) {
+		t.Errorf("Expected the error of the malformed manifest, got %v", selection.err)
+	}
+	if run, skip := buildRunSkipPatterns(selection, selection.tests, args); run != "^$" || skip != "" {
+		t.Errorf("buildRunSkipPatterns() = %q, %q, want \"^$\", \"\"", run, skip)
+	}
+
+	// Without labels, the tests run as normal
+	os.Args = []string{"theBinDoesntMatter", "-test.v"}
+	MutateTestFilterByLabels()
+	if expected := []string{"theBinDoesntMatter", "-test.v"}; !slices.Equal(os.Args, expected) {
+		t.Errorf("Expected %v, got %v", expected, os.Args)
+	}
 }
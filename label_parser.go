@@ -0,0 +1,240 @@
+package gotest_labels
+
+// label_parser.go extracts the `@key[=value]` labels from Go comments. Any number of labels
+// can be written in one comment line or in one block comment, mixed with ordinary prose:
+//
+//	// @group=demo @env=dev
+//	/* @owner="team a"
+//	   @regression */
+//
+// A label without value is treated as `key=true`. Values containing spaces are quoted with
+// double quotes (Go escapes apply), single quotes or backquotes (taken literally).
+//
+// The labels can also be written in the Go directive form, which gofmt and godoc treat as a
+// directive rather than the prose of the doc comment:
+//
+//	//gotest:label group=demo env=dev
+//
+// The `@` marker can be replaced, e.g. by `+label:`, via the TEST_LABELS_MARKER env variable or
+// the LabelMarker variable, if `@` collides with the existing doc comment conventions.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultLabelMarker is the default prefix of labels in comments, e.g. `// @group=demo`
+const DefaultLabelMarker = "@"
+
+// LabelDirective is the Go directive to write labels without marker, e.g. `//gotest:label group=demo`
+const LabelDirective = "//gotest:label"
+
+// LabelMarker is the prefix of labels in comments, the TEST_LABELS_MARKER env variable overrides it.
+var LabelMarker = DefaultLabelMarker
+
+func getLabelMarker() string {
+	if marker := os.Getenv("TEST_LABELS_MARKER"); marker != "" {
+		return marker
+	}
+	return LabelMarker
+}
+
+// Diagnostic is a syntax problem of the labels in comments, e.g. a label without key
+type Diagnostic struct {
+	Pos     token.Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// A syntax problem of labels at the byte offset of the parsed text
+type labelIssue struct {
+	offset  int
+	message string
+}
+
+// Get the labels from a comment group, the later label wins on a duplicated key.
+func getCommentLabels(doc *ast.CommentGroup) TestLabels {
+	labels := make(TestLabels)
+	if doc == nil {
+		return labels
+	}
+	marker := getLabelMarker()
+	for _, comment := range doc.List {
+		text, _, commentMarker := commentLabelText(comment.Text, marker)
+		commentLabels, _ := parseLabels(text, commentMarker)
+		maps.Copy(labels, commentLabels)
+	}
+	return labels
+}
+
+// Get the diagnostics of the labels in a comment group, including the keys duplicated in the group.
+func getCommentDiagnostics(fset *token.FileSet, doc *ast.CommentGroup) []Diagnostic {
+	var diagnostics []Diagnostic
+	marker := getLabelMarker()
+	seen := map[string]bool{}
+	for _, comment := range doc.List {
+		text, offset, commentMarker := commentLabelText(comment.Text, marker)
+		labels, issues := parseLabels(text, commentMarker)
+		for _, issue := range issues {
+			diagnostics = append(diagnostics, Diagnostic{
+				Pos:     fset.Position(comment.Slash + token.Pos(offset+issue.offset)),
+				Message: issue.message,
+			})
+		}
+		for _, key := range slices.Sorted(maps.Keys(labels)) {
+			if seen[key] {
+				diagnostics = append(diagnostics, Diagnostic{
+					Pos:     fset.Position(comment.Slash),
+					Message: fmt.Sprintf("duplicate label key %q in the comment", key),
+				})
+			}
+			seen[key] = true
+		}
+	}
+	return diagnostics
+}
+
+// Get the text to parse labels from a comment, its byte offset in the comment and the label marker to use.
+// The `//gotest:label` directive has no marker.
+func commentLabelText(text string, marker string) (string, int, string) {
+	if args, ok := directiveArgs(text); ok {
+		return args, len(LabelDirective), ""
+	}
+	return commentText(text), 2, marker
+}
+
+// Get the arguments of the `//gotest:label` directive, it returns false if the comment is not the directive
+func directiveArgs(text string) (string, bool) {
+	args, ok := strings.CutPrefix(text, LabelDirective)
+	if !ok || (args != "" && !unicode.IsSpace([]rune(args)[0])) {
+		return "", false
+	}
+	return args, true
+}
+
+// Strip the comment markers for both styles in `// @key=value` and `/* @key=value */`
+func commentText(text string) string {
+	if strings.HasPrefix(text, "//") {
+		return text[2:]
+	}
+	text = strings.TrimPrefix(text, "/*")
+	return strings.TrimSuffix(text, "*/")
+}
+
+// Parse all the `@key[=value]` tokens in the given text. Words not starting with the label marker are ignored.
+// If the marker is empty, all the words are parsed as labels. The syntax issues are returned along with the labels.
+func parseLabels(text string, marker string) (TestLabels, []labelIssue) {
+	labels := make(TestLabels)
+	var issues []labelIssue
+	runes := []rune(text)
+	markerRunes := []rune(marker)
+	n := len(runes)
+	i := 0
+
+	addIssue := func(pos int, format string, args ...any) {
+		issues = append(issues, labelIssue{
+			offset:  len(string(runes[:pos])),
+			message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for i < n {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		// A label shall start a word, e.g. the `@` in `someone@example.com` is not a label.
+		if !hasRunesPrefix(runes[i:], markerRunes) {
+			for i < n && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			continue
+		}
+		labelStart := i
+		i += len(markerRunes)
+
+		start := i
+		for i < n && runes[i] != '=' && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		key := string(runes[start:i])
+
+		value := DefaultLabelValue
+		if i < n && runes[i] == '=' {
+			valueStart := i + 1
+			var closed bool
+			value, i, closed = scanLabelValue(runes, valueStart)
+			if !closed {
+				addIssue(valueStart, "unclosed quote in label value %s", value)
+			}
+			if strings.Contains(value, "*/") || strings.Contains(value, "/*") {
+				addIssue(valueStart, "label value %q contains a comment marker", value)
+			}
+		}
+
+		switch {
+		case key == "" && start < n && runes[start] == '=':
+			addIssue(labelStart, "empty label key")
+		case key == "":
+			addIssue(labelStart, "label marker %q is not followed by a key", marker)
+		default:
+			if _, ok := labels[key]; ok {
+				addIssue(labelStart, "duplicate label key %q", key)
+			}
+			labels[key] = value
+		}
+	}
+	return labels, issues
+}
+
+// Scan a label value from position i, it returns the value, the position after it and false if the
+// value has an unclosed quote.
+func scanLabelValue(runes []rune, i int) (string, int, bool) {
+	n := len(runes)
+	closed := true
+	if i < n && (runes[i] == '"' || runes[i] == '\'' || runes[i] == '`') {
+		quote := runes[i]
+		start := i
+		i++
+		for i < n && runes[i] != quote {
+			if quote == '"' && runes[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i < n {
+			i++
+			literal := string(runes[start:i])
+			if quote != '"' {
+				return literal[1 : len(literal)-1], i, closed
+			}
+			if value, err := strconv.Unquote(literal); err == nil {
+				return value, i, closed
+			}
+			return literal[1 : len(literal)-1], i, closed
+		}
+		// An unclosed quote is taken literally up to the next space.
+		i = start
+		closed = false
+	}
+
+	start := i
+	for i < n && !unicode.IsSpace(runes[i]) {
+		i++
+	}
+	return string(runes[start:i]), i, closed
+}
+
+func hasRunesPrefix(runes []rune, prefix []rune) bool {
+	return len(runes) >= len(prefix) && slices.Equal(runes[:len(prefix)], prefix)
+}
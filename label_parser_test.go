@@ -0,0 +1,224 @@
+package gotest_labels
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := map[string]struct {
+		text string
+		want TestLabels
+	}{
+		"empty": {
+			text: "",
+			want: TestLabels{},
+		},
+		"single label": {
+			text: " @group=demo",
+			want: TestLabels{"group": "demo"},
+		},
+		"multiple labels in one line": {
+			text: " @group=demo @env=dev",
+			want: TestLabels{"group": "demo", "env": "dev"},
+		},
+		"label without value": {
+			text: " @regression @group=demo",
+			want: TestLabels{"regression": DefaultLabelValue, "group": "demo"},
+		},
+		"labels mixed with prose": {
+			text: " Ask someone@example.com about @owner=payments before removing it",
+			want: TestLabels{"owner": "payments"},
+		},
+		"multi-line block comment": {
+			text: " @a=1\n @b=2 ",
+			want: TestLabels{"a": "1", "b": "2"},
+		},
+		"block comment with leading stars": {
+			text: "\n * @a=1\n * @b\n ",
+			want: TestLabels{"a": "1", "b": DefaultLabelValue},
+		},
+		"double quoted value": {
+			text: ` @owner="team a" @env=dev`,
+			want: TestLabels{"owner": "team a", "env": "dev"},
+		},
+		"double quoted value with escapes": {
+			text: ` @note="say \"hi\" @there"`,
+			want: TestLabels{"note": `say "hi" @there`},
+		},
+		"single quoted value": {
+			text: ` @owner='team @b' @env=dev`,
+			want: TestLabels{"owner": "team @b", "env": "dev"},
+		},
+		"unclosed quote": {
+			text: ` @owner="team @env=dev`,
+			want: TestLabels{"owner": `"team`, "env": "dev"},
+		},
+		"empty key is ignored": {
+			text: " @=x @ @key=val",
+			want: TestLabels{"key": "val"},
+		},
+		"later duplicated key wins": {
+			text: " @env=dev @env=prod",
+			want: TestLabels{"env": "prod"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, _ := parseLabels(test.text, DefaultLabelMarker)
+			if !maps.Equal(got, test.want) {
+				t.Errorf("parseLabels(%q) = %v, want %v", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetCommentLabels(t *testing.T) {
+	doc := &ast.CommentGroup{
+		List: []*ast.Comment{
+			{Text: "// A test with @group=demo @env=dev"},
+			{Text: "/* @a=1\n   @b=2 */"},
+		},
+	}
+	want := TestLabels{"group": "demo", "env": "dev", "a": "1", "b": "2"}
+
+	got := getCommentLabels(doc)
+	if !maps.Equal(got, want) {
+		t.Errorf("getCommentLabels() = %v, want %v", got, want)
+	}
+
+	if labels := getCommentLabels(nil); len(labels) != 0 {
+		t.Errorf("getCommentLabels(nil) = %v, want empty labels", labels)
+	}
+}
+
+func TestParseLabelsWithMarker(t *testing.T) {
+	tests := map[string]struct {
+		text   string
+		marker string
+		want   TestLabels
+	}{
+		"custom marker": {
+			text:   " @deprecated use TestB, +label:group=demo +label:slow",
+			marker: "+label:",
+			want:   TestLabels{"group": "demo", "slow": DefaultLabelValue},
+		},
+		"no marker": {
+			text:   " group=demo env=\"dev 1\" regression",
+			marker: "",
+			want:   TestLabels{"group": "demo", "env": "dev 1", "regression": DefaultLabelValue},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, _ := parseLabels(test.text, test.marker)
+			if !maps.Equal(got, test.want) {
+				t.Errorf("parseLabels(%q, %q) = %v, want %v", test.text, test.marker, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetCommentLabelsWithDirective(t *testing.T) {
+	doc := &ast.CommentGroup{
+		List: []*ast.Comment{
+			{Text: "// TestA checks something, @see TestB"},
+			{Text: "//gotest:label group=demo env=dev"},
+			{Text: "//gotest:labels notme=true"},
+			{Text: "// gotest:label notme=true"},
+		},
+	}
+	want := TestLabels{"see": DefaultLabelValue, "group": "demo", "env": "dev"}
+	if got := getCommentLabels(doc); !maps.Equal(got, want) {
+		t.Errorf("getCommentLabels() = %v, want %v", got, want)
+	}
+
+	t.Run("custom marker from env", func(t *testing.T) {
+		t.Setenv("TEST_LABELS_MARKER", "+label:")
+
+		want := TestLabels{"group": "demo", "env": "dev"}
+		if got := getCommentLabels(doc); !maps.Equal(got, want) {
+			t.Errorf("getCommentLabels() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestParseLabelsIssues(t *testing.T) {
+	tests := map[string]struct {
+		text string
+		want []labelIssue
+	}{
+		"no issue": {
+			text: " @group=demo @owner=\"team a\"",
+			want: nil,
+		},
+		"empty key": {
+			text: " @=demo",
+			want: []labelIssue{{offset: 1, message: "empty label key"}},
+		},
+		"marker without key": {
+			text: " see @ here",
+			want: []labelIssue{{offset: 5, message: `label marker "@" is not followed by a key`}},
+		},
+		"duplicate key": {
+			text: " @env=dev @env=prod",
+			want: []labelIssue{{offset: 10, message: `duplicate label key "env"`}},
+		},
+		"unclosed quote": {
+			text: ` @owner="team a`,
+			want: []labelIssue{{offset: 8, message: `unclosed quote in label value "team`}},
+		},
+		"comment marker in value": {
+			text: " @path=a/*b",
+			want: []labelIssue{{offset: 7, message: `label value "a/*b" contains a comment marker`}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, got := parseLabels(test.text, DefaultLabelMarker)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("parseLabels(%q) issues = %v, want %v", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetCommentDiagnostics(t *testing.T) {
+	src := `package demo
+
+// @=demo
+// @env=dev
+//gotest:label env=prod
+func TestA(t *testing.T) {}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "demo_test.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+
+	var got []string
+	for _, d := range getCommentDiagnostics(fset, f.Comments[0]) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"demo_test.go:3:4: empty label key",
+		`demo_test.go:5:1: duplicate label key "env" in the comment`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("getCommentDiagnostics() = %q, want %q", got, want)
+	}
+}
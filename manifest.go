@@ -0,0 +1,73 @@
+package gotest_labels
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ManifestFileName is the sidecar file in a package directory which maps test names to labels.
+// It labels the tests whose doc comments can't be edited, e.g. generated or vendored tests:
+//
+//	{
+//	  "TestGeneratedAlpha": {"group": "demo", "regression": ""}
+//	}
+//
+// An empty value is treated as DefaultLabelValue, the same as `// @regression` in comments.
+const ManifestFileName = "testlabels.json"
+
+// Load the label manifest from the given package directory.
+// A missing manifest is not an error and returns a nil map.
+func loadManifest(dir string) (map[string]TestLabels, error) {
+	path := filepath.Join(dir, ManifestFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s, err: %v", path, err)
+	}
+
+	manifest := map[string]TestLabels{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s, err: %v", path, err)
+	}
+	for _, labels := range manifest {
+		for key, value := range labels {
+			if value == "" {
+				labels[key] = DefaultLabelValue
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// ManifestSource is the LabelSource of the ManifestFileName sidecar files in the package directories.
+// The manifest exists to label tests whose source can't be edited, entries naming tests that no longer
+// exist are warned and dropped.
+type ManifestSource struct{}
+
+func (ManifestSource) Name() string {
+	return ManifestFileName
+}
+
+func (ManifestSource) Labels(dir string, tests map[string]TestLabels) (map[string]TestLabels, error) {
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(manifest)) {
+		if _, ok := tests[name]; !ok {
+			log.Printf("Warning: %s names test %s which is not found in %s",
+				ManifestFileName, name, dir)
+			delete(manifest, name)
+		}
+	}
+	return manifest, nil
+}
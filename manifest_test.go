@@ -0,0 +1,77 @@
+package gotest_labels
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	t.Run("Missing manifest", func(t *testing.T) {
+		t.Parallel()
+
+		manifest, err := loadManifest(t.TempDir())
+		if err != nil {
+			t.Errorf("Expected no error for missing manifest, got %v", err)
+		}
+		if manifest != nil {
+			t.Errorf("Expected nil manifest, got %v", manifest)
+		}
+	})
+
+	t.Run("Valid manifest", func(t *testing.T) {
+		t.Parallel()
+
+		manifest, err := loadManifest("./examples/manifest")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(manifest) != 2 {
+			t.Errorf("Expected 2 entries, got %v", manifest)
+		}
+		if manifest["TestGeneratedAlpha"]["group"] != "demo" {
+			t.Errorf("Expected group=demo, got %v", manifest["TestGeneratedAlpha"])
+		}
+		if manifest["TestGeneratedAlpha"]["regression"] != DefaultLabelValue {
+			t.Errorf("Expected empty value to be %s, got %v", DefaultLabelValue, manifest["TestGeneratedAlpha"])
+		}
+	})
+
+	t.Run("Malformed manifest", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(`{"TestA": "group=demo"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadManifest(dir); err == nil {
+			t.Errorf("Expected an error for malformed manifest")
+		}
+	})
+}
+
+func TestManifestSource(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"TestA": {"env": "prod"}, "TestB": {"group": "demo"}, "TestGone": {"group": "demo"}}`
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	funcs := map[string]TestLabels{
+		"TestA": {"group": "demo", "env": "dev"},
+		"TestB": {},
+	}
+
+	if err := applyLabelSources([]LabelSource{ManifestSource{}}, dir, funcs); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(funcs) != 2 {
+		t.Errorf("Expected no tests added for stale entries, got %v", funcs)
+	}
+	if funcs["TestA"]["env"] != "prod" || funcs["TestA"]["group"] != "demo" {
+		t.Errorf("Expected manifest to override env only, got %v", funcs["TestA"])
+	}
+	if funcs["TestB"]["group"] != "demo" {
+		t.Errorf("Expected manifest labels on TestB, got %v", funcs["TestB"])
+	}
+}
@@ -0,0 +1,194 @@
+package gotest_labels
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The test name pattern of the go test -run, -skip, -bench and -fuzz flags, matched the same way as go test.
+// The pattern is split by the unbracketed `|` into alternatives and each alternative by the unbracketed `/` into
+// the patterns of the name levels, e.g. `TestA/case1|TestB` matches the subtest case1 of TestA and TestB as a whole.
+// Each level of a test name is matched by the pattern of the same level, the levels beyond the pattern always match.
+type namePattern struct {
+	source       string             // The pattern given by the user
+	alternatives [][]string         // The level patterns of each alternative
+	regexes      [][]*regexp.Regexp // The compiled level patterns of each alternative
+}
+
+// Compile the name pattern of the go test flag, it's nil for an empty pattern which matches all the tests
+func compileNamePattern(flag string, pattern string) (*namePattern, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	p := &namePattern{source: pattern, alternatives: splitNamePattern(pattern)}
+	for _, levels := range p.alternatives {
+		regexes := make([]*regexp.Regexp, len(levels))
+		for i, level := range levels {
+			regex, err := regexp.Compile(level)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp for element %d of -%s (%q): %v", i, flag, level, err)
+			}
+			regexes[i] = regex
+		}
+		p.regexes = append(p.regexes, regexes)
+	}
+	return p, nil
+}
+
+// Split the pattern into the alternatives of level patterns, the `|` and `/` in brackets, in parentheses or
+// escaped don't split the pattern, the same as go test.
+func splitNamePattern(pattern string) [][]string {
+	var alternatives [][]string
+	var levels []string
+	brackets, parens := 0, 0
+	for i := 0; i < len(pattern); {
+		switch pattern[i] {
+		case '[':
+			brackets++
+		case ']':
+			// An unmatched ']' is legal
+			brackets = max(brackets-1, 0)
+		case '(':
+			if brackets == 0 {
+				parens++
+			}
+		case ')':
+			if brackets == 0 {
+				parens--
+			}
+		case '\\':
+			i++
+		case '/', '|':
+			if brackets == 0 && parens == 0 {
+				levels = append(levels, pattern[:i])
+				if pattern[i] == '|' {
+					alternatives = append(alternatives, levels)
+					levels = nil
+				}
+				pattern = pattern[i+1:]
+				i = 0
+				continue
+			}
+		}
+		i++
+	}
+	return append(alternatives, append(levels, pattern))
+}
+
+func (p *namePattern) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.source
+}
+
+// Check whether the test name matches the pattern, and whether it only matches partially, i.e. the pattern has more
+// levels than the name so it runs and its subtests are matched by the rest levels. A nil pattern matches any name.
+func (p *namePattern) match(name string) (ok bool, partial bool) {
+	if p == nil {
+		return true, false
+	}
+	elems := strings.Split(name, "/")
+	for _, regexes := range p.regexes {
+		if matchLevels(regexes, elems) {
+			return true, len(elems) < len(regexes)
+		}
+	}
+	return false, false
+}
+
+// Check whether the test name is skipped by the -skip pattern, which only skips the names it matches fully
+func (p *namePattern) skips(name string) bool {
+	if p == nil {
+		return false
+	}
+	ok, partial := p.match(name)
+	return ok && !partial
+}
+
+// Get the patterns of the subtest levels of the alternatives matching the test name partially, e.g. `case1` of
+// `TestA/case1` for TestA, so the generated pattern of the test keeps selecting the same subtests as the user's.
+// It's nil if the name is matched fully, i.e. all its subtests are matched.
+func (p *namePattern) subtestPatterns(name string) []string {
+	if p == nil {
+		return nil
+	}
+	elems := strings.Split(name, "/")
+	suffixes := []string{}
+	for i, regexes := range p.regexes {
+		if !matchLevels(regexes, elems) {
+			continue
+		}
+		if len(elems) >= len(regexes) {
+			return nil
+		}
+		suffix := strings.Join(p.alternatives[i][len(elems):], "/")
+		if !slices.Contains(suffixes, suffix) {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	return suffixes
+}
+
+func matchLevels(regexes []*regexp.Regexp, elems []string) bool {
+	for i, elem := range elems {
+		if i >= len(regexes) {
+			break
+		}
+		if !regexes[i].MatchString(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter the tests matched by the name pattern, fully or partially as go test runs them
+func filterTestFuncsByPattern(funcs map[string]TestLabels, pattern *namePattern) map[string]TestLabels {
+	if pattern == nil {
+		return funcs
+	}
+	matched := make(map[string]TestLabels)
+	for name, labels := range funcs {
+		if ok, _ := pattern.match(name); ok {
+			matched[name] = labels
+		}
+	}
+	return matched
+}
+
+// Set the value of the go test flag in place, e.g. `-test.run=X` or `-test.run X`, instead of appending another
+// one and relying on the last one to win. The earlier occurrences of the flag are removed, and the flag is appended
+// if it's not given.
+func setFlag(args []string, flag string, value string) []string {
+	last := -1
+	for i, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			last = i
+		}
+	}
+	if last < 0 {
+		return append(args, flag, value)
+	}
+
+	newArgs := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == flag:
+			// The value of the flag is the next arg
+			if i == last {
+				newArgs = append(newArgs, flag, value)
+			}
+			i++
+		case strings.HasPrefix(arg, flag+"="):
+			if i == last {
+				newArgs = append(newArgs, flag+"="+value)
+			}
+		default:
+			newArgs = append(newArgs, arg)
+		}
+	}
+	return newArgs
+}
@@ -0,0 +1,194 @@
+package gotest_labels
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSplitNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    [][]string
+	}{
+		{pattern: "TestA", want: [][]string{{"TestA"}}},
+		{pattern: "TestA/case1", want: [][]string{{"TestA", "case1"}}},
+		{pattern: "TestA/case1|TestB", want: [][]string{{"TestA", "case1"}, {"TestB"}}},
+		{pattern: "Test(A|B)/[/|]x", want: [][]string{{"Test(A|B)", "[/|]x"}}},
+		{pattern: `TestA\/x\|y`, want: [][]string{{`TestA\/x\|y`}}},
+		{pattern: "TestA/", want: [][]string{{"TestA", ""}}},
+	}
+	for _, tt := range tests {
+		got := splitNamePattern(tt.pattern)
+		if !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("splitNamePattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestNamePatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		name        string
+		wantOK      bool
+		wantPartial bool
+	}{
+		{pattern: "", name: "TestA", wantOK: true},
+		{pattern: "TestA", name: "TestA", wantOK: true},
+		{pattern: "TestA", name: "TestA/case1", wantOK: true},
+		{pattern: "TestA", name: "TestB", wantOK: false},
+		{pattern: "TestA/case1", name: "TestA", wantOK: true, wantPartial: true},
+		{pattern: "TestA/case1", name: "TestA/case1", wantOK: true},
+		{pattern: "TestA/case1", name: "TestA/case2", wantOK: false},
+		{pattern: "TestA/case1|TestB", name: "TestB/case2", wantOK: true},
+		// Each level is matched separately, the whole name isn't matched by a single regexp
+		{pattern: "^TestA/case1$", name: "TestA/case1", wantOK: true},
+		{pattern: "A/1$", name: "TestA/case1/x", wantOK: true},
+	}
+	for _, tt := range tests {
+		p, err := compileNamePattern("test.run", tt.pattern)
+		if err != nil {
+			t.Fatalf("compileNamePattern(%q) error = %v", tt.pattern, err)
+		}
+		ok, partial := p.match(tt.name)
+		if ok != tt.wantOK || partial != tt.wantPartial {
+			t.Errorf("%q.match(%q) = %v, %v, want %v, %v", tt.pattern, tt.name, ok, partial, tt.wantOK, tt.wantPartial)
+		}
+	}
+}
+
+func TestCompileNamePatternError(t *testing.T) {
+	for _, pattern := range []string{"TestA/(case", "*Test", "TestA|[b"} {
+		if _, err := compileNamePattern("test.run", pattern); err == nil {
+			t.Errorf("compileNamePattern(%q) expected an error", pattern)
+		}
+	}
+}
+
+func TestNamePatternSkips(t *testing.T) {
+	p, err := compileNamePattern("test.skip", "TestA/eu|TestB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"TestA":       false, // The partial match of -skip doesn't skip the parent
+		"TestA/eu":    true,
+		"TestA/us":    false,
+		"TestB":       true,
+		"TestB/any":   true,
+		"TestC":       false,
+		"TestA/eu/x1": true,
+	} {
+		if got := p.skips(name); got != want {
+			t.Errorf("skips(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if (*namePattern)(nil).skips("TestA") {
+		t.Errorf("nil pattern skips(TestA) = true, want false")
+	}
+}
+
+func TestBuildRunPattern(t *testing.T) {
+	tests := []struct {
+		name  string
+		tests []string
+		user  string
+		want  string
+	}{
+		{name: "no user pattern", tests: []string{"TestA", "TestB"}, want: "^Test[AB]$"},
+		{name: "matched fully", tests: []string{"TestA", "TestB"}, user: "Test", want: "^Test[AB]$"},
+		{name: "subtest part kept", tests: []string{"TestA", "TestB"}, user: "TestA/case1|TestB",
+			want: "^TestB$|^TestA$/case1"},
+		{name: "subtest parts of alternatives", tests: []string{"TestA"}, user: "TestA/case1|A/case2/x",
+			want: "^TestA$/case1|^TestA$/case2/x"},
+		{name: "full match wins over partial", tests: []string{"TestA"}, user: "TestA/case1|TestA",
+			want: "^TestA$"},
+		{name: "labeled subtest", tests: []string{"TestA/eu"}, user: "TestA/eu/fast",
+			want: "^TestA$/^eu$/fast"},
+		{name: "nothing selected", user: "TestA/case1", want: "^$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := compileNamePattern("test.run", tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected := map[string]TestLabels{}
+			for _, name := range tt.tests {
+				selected[name] = TestLabels{}
+			}
+			if got := buildRunPattern(selected, user); got != tt.want {
+				t.Errorf("buildRunPattern() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "appended", args: []string{"bin", "-test.v"}, want: []string{"bin", "-test.v", "-test.run", "^X$"}},
+		{name: "value in place", args: []string{"bin", "-test.run=A", "-test.v"},
+			want: []string{"bin", "-test.run=^X$", "-test.v"}},
+		{name: "separate value in place", args: []string{"bin", "-test.run", "A", "-test.v"},
+			want: []string{"bin", "-test.run", "^X$", "-test.v"}},
+		{name: "earlier flags removed", args: []string{"bin", "-test.run", "A", "-test.v", "-test.run=B"},
+			want: []string{"bin", "-test.v", "-test.run=^X$"}},
+		{name: "flag without value", args: []string{"bin", "-test.run"}, want: []string{"bin", "-test.run", "^X$"}},
+		{name: "other flags with the prefix", args: []string{"bin", "-test.runx=A"},
+			want: []string{"bin", "-test.runx=A", "-test.run", "^X$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setFlag(slices.Clone(tt.args), "-test.run", "^X$"); !slices.Equal(got, tt.want) {
+				t.Errorf("setFlag() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutateWithUserPatterns(t *testing.T) {
+	origDefaultPkg := defaultPkg
+	defer func() { defaultPkg = origDefaultPkg }()
+	defaultPkg = "./examples/subtests"
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantTests []string
+		wantArgs  []string
+	}{
+		{
+			name:      "Subtest pattern of the user",
+			args:      []string{"bin", "-test.run", "TestEndpoints|TestRuntimeRegions/eu", "-labels", "region=eu"},
+			wantTests: []string{"TestEndpoints/eu-health", "TestRuntimeRegions"},
+			wantArgs:  []string{"bin", "-test.run", "^TestEndpoints$/^eu-health$|^TestRuntimeRegions$/eu"},
+		},
+		{
+			name:      "Skip pattern of the user",
+			args:      []string{"bin", "-test.skip=TestRegions/eu", "-labels", "region=eu"},
+			wantTests: []string{"TestEndpoints/eu-health", "TestRuntimeRegions"},
+			wantArgs:  []string{"bin", "-test.skip=TestRegions/eu", "-test.run", "^TestRuntimeRegions$|^TestEndpoints$/^eu-health$"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origArgs := os.Args
+			defer func() { os.Args = origArgs }()
+			os.Args = slices.Clone(tt.args)
+
+			tests := MutateTestFilterByLabels()
+
+			if got := slices.Sorted(maps.Keys(tests)); !slices.Equal(got, tt.wantTests) {
+				t.Errorf("Expected tests %v, got %v", tt.wantTests, got)
+			}
+			if !slices.Equal(os.Args, tt.wantArgs) {
+				t.Errorf("Expected args %q, got %q", tt.wantArgs, os.Args)
+			}
+		})
+	}
+}
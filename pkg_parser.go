@@ -5,25 +5,121 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/tools/go/packages"
 )
 
 const DefaultLabelValue = "true"
 
-var defaultPkg = "./..."
+// The name prefixes of the functions run by go test
+const (
+	testPrefix      = "Test"
+	benchmarkPrefix = "Benchmark"
+	fuzzPrefix      = "Fuzz"
+	examplePrefix   = "Example"
+)
+
+// The output comment of runnable examples, the same as go/doc
+var exampleOutputRegex = regexp.MustCompile(`(?i)^[[:space:]]*(unordered )?output:`)
+
+// The testing type name of the single parameter expected for each kind of functions
+var testParamTypes = map[string]string{
+	testPrefix:      "T",
+	benchmarkPrefix: "B",
+	fuzzPrefix:      "F",
+}
+
+// The suffix of the import path of test binaries in their build info
+const testBinarySuffix = ".test"
+
+// The import path of the testing package
+const testingPath = "testing"
+
+// The reserved labels derived from the test code when DeriveLabels is enabled
+const (
+	parallelLabel   = "parallel"    // The test calls t.Parallel()
+	shortAwareLabel = "short-aware" // The test checks testing.Short()
+	netLabel        = "net"         // The test file imports a network or database package
+)
+
+// The imports of test files labeled with `net=true` when DeriveLabels is enabled
+var netImportPaths = []string{"net", "net/http", "database/sql"}
+
+// DeriveLabels enables the labels derived from the test code, `parallel`, `short-aware` and `net`, which are
+// added to the written labels without overriding them. The TEST_LABELS_DERIVED env variable enables it as well.
+var DeriveLabels = false
+
+func deriveLabelsEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("TEST_LABELS_DERIVED"))
+	return enabled || DeriveLabels
+}
 
-// Get go packages in "." directory since the packages and paths are actually processed earlier than
-// executing the test binaries internally by the go test command. The current package only needs to
-// take care of the current directory.
-func getPackages() ([]*packages.Package, error) {
+// The import path of testify suites, which are selected via the -testify.m flag
+const testifySuitePath = "github.com/stretchr/testify/suite"
+
+// The package pattern to discover the tests, it's empty to discover the package under test only
+var defaultPkg = ""
+
+// RecursiveDiscovery enables discovering the tests in the current directory and all its sub directories, i.e. the
+// "./..." packages, instead of the package under test only. The TEST_LABELS_RECURSIVE env variable enables it as
+// well. The test names in different packages may collide in the recursive discovery.
+var RecursiveDiscovery = false
+
+// Get the package pattern to discover the tests. go test runs each test binary in its package directory, the package
+// under test is identified by the import path of the binary, e.g. `example.com/pkg` of `example.com/pkg.test`,
+// and falls back to the current directory.
+func getPackagePattern(info *debug.BuildInfo) string {
+	recursive, _ := strconv.ParseBool(os.Getenv("TEST_LABELS_RECURSIVE"))
+	switch {
+	case defaultPkg != "":
+		return defaultPkg
+	case recursive || RecursiveDiscovery:
+		return "./..."
+	case getTestPackagePath(info) != "":
+		return getTestPackagePath(info)
+	}
+	return "."
+}
+
+// Get the import path of the package under test from the build info of the test binary, it's empty if unknown
+func getTestPackagePath(info *debug.BuildInfo) string {
+	if info == nil || !strings.HasSuffix(info.Path, testBinarySuffix) {
+		return ""
+	}
+	// The test files given on the command line are built as a pseudo package which can't be loaded by its path
+	if path := strings.TrimSuffix(info.Path, testBinarySuffix); path != "command-line-arguments" {
+		return path
+	}
+	return ""
+}
+
+// Load the go packages of the pattern by go list, since the packages and paths are actually processed earlier
+// than executing the test binaries internally by the go test command.
+// The packages are loaded with the build tags and target platform of the running test binary, so the
+// discovery sees the same files as the binary was compiled from, e.g. the `//go:build integration` files
+// with `go test -tags integration`.
+func getPackages(dir string, pattern string, info *debug.BuildInfo) ([]*packages.Package, error) {
+	buildFlags, env := getBuildSettings(info)
 	cfg := &packages.Config{
-		Mode:  packages.NeedName | packages.NeedFiles,
-		Tests: true,
+		Mode:       packages.NeedName | packages.NeedFiles,
+		Dir:        dir,
+		Tests:      true,
+		BuildFlags: buildFlags,
+		Env:        append(os.Environ(), env...),
 	}
-	pkgs, err := packages.Load(cfg, defaultPkg)
+	pkgs, err := packages.Load(cfg, pattern)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to load packages: %v", err)
@@ -39,6 +135,36 @@ func getPackages() ([]*packages.Package, error) {
 	return validPkgs, nil
 }
 
+// Get the build flags and env variables to load the packages from the build settings of the test binary.
+// The TEST_LABELS_TAGS env variable overrides the build tags, e.g. for binaries built without build info.
+// The GOFLAGS env variable is honored by go list as well, the build tags of the binary take precedence over it.
+// The -race, -msan and -asan flags and GOEXPERIMENT of the binary are kept as well, since they imply the `race`,
+// `msan`, `asan` and `goexperiment.*` build tags.
+func getBuildSettings(info *debug.BuildInfo) ([]string, []string) {
+	var buildFlags, env []string
+	tags, hasTags := os.LookupEnv("TEST_LABELS_TAGS")
+	if info != nil {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "-tags":
+				if !hasTags {
+					tags, hasTags = setting.Value, true
+				}
+			case "-race", "-msan", "-asan":
+				if setting.Value == "true" {
+					buildFlags = append(buildFlags, setting.Key)
+				}
+			case "GOOS", "GOARCH", "CGO_ENABLED", "GOEXPERIMENT":
+				env = append(env, setting.Key+"="+setting.Value)
+			}
+		}
+	}
+	if hasTags && tags != "" {
+		buildFlags = append(buildFlags, "-tags="+tags)
+	}
+	return buildFlags, env
+}
+
 // Find all *_test.go files under the given package
 func getTestFiles(pkg *packages.Package) []string {
 	var testFiles []string
@@ -53,50 +179,512 @@ func getTestFiles(pkg *packages.Package) []string {
 	return testFiles
 }
 
-// Find all Test* functions with (t *testing.T) signature and matching the label filter in given test files
-// It returns a map of function names to their labels
+// Group the *_test.go files of the given packages by their directories.
+// The same test file can be listed by several package variants, e.g. "pkg" and "pkg [pkg.test]".
+func getTestFilesByDir(pkgs []*packages.Package) map[string][]string {
+	filesByDir := map[string][]string{}
+	for _, pkg := range pkgs {
+		for _, file := range getTestFiles(pkg) {
+			dir := filepath.Dir(file)
+			if !slices.Contains(filesByDir[dir], file) {
+				filesByDir[dir] = append(filesByDir[dir], file)
+			}
+		}
+	}
+	return filesByDir
+}
+
+// Find all Test* functions with (t *testing.T) signature, Benchmark* functions with (b *testing.B) signature,
+// Fuzz* functions with (f *testing.F) signature and runnable Example* functions matching the label filter
+// in given test files, as well as the labeled subtests of the Test* functions
+// It returns a map of function names to their labels, the subtests are named as `TestX/subtest`
 func FindTestFuncs(testFiles []string, filterAST Node) (map[string]TestLabels, error) {
-	testFuncs := map[string]TestLabels{}
-	fset := token.NewFileSet()
+	funcs, _, err := FindTestFuncsWithDiagnostics(testFiles, filterAST)
+	return funcs, err
+}
+
+// Find the test functions like FindTestFuncs, it also returns the diagnostics of the malformed labels in
+// the comments of given test files, ordered by their positions.
+func FindTestFuncsWithDiagnostics(testFiles []string, filterAST Node) (map[string]TestLabels, []Diagnostic, error) {
+	d, err := discoverTestFuncs(testFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filterTestFuncsByLabels(d.funcs, filterAST), d.diagnostics, nil
+}
 
-	for _, file := range testFiles {
-		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+// The test functions discovered in test files
+type discovery struct {
+	funcs        map[string]TestLabels     // The test functions and labeled subtests with their labels
+	runtimeTests map[string]bool           // The tests calling Run with subtest names or labels only known at runtime
+	suiteMethods map[string]bool           // The testify suite methods named as subtests, e.g. `TestMySuite/TestCreate`
+	diagnostics  []Diagnostic              // The syntax problems of labels in the comments
+	positions    map[string]token.Position // The positions of the test functions and labeled subtests
+}
+
+// The testify suites found in test files, a suite is run by entry tests like
+// `func TestMySuite(t *testing.T) { suite.Run(t, new(MySuite)) }`
+type suiteIndex struct {
+	Entries    map[string][]string              `json:"entries"`    // The entry test names by suite type
+	TypeLabels map[string]TestLabels            `json:"typeLabels"` // The labels of suite type declarations
+	Methods    map[string]map[string]TestLabels `json:"methods"`    // The test methods with labels by suite type
+	Positions  map[string]token.Position        `json:"positions"`  // The positions of the test methods, e.g. `MySuite.TestCreate`
+}
+
+func newSuiteIndex() *suiteIndex {
+	return &suiteIndex{
+		Entries:    map[string][]string{},
+		TypeLabels: map[string]TestLabels{},
+		Methods:    map[string]map[string]TestLabels{},
+		Positions:  map[string]token.Position{},
+	}
+}
+
+// The test functions discovered in one test file, which are cached by the file
+type fileDiscovery struct {
+	Funcs        map[string]TestLabels     `json:"funcs"`
+	Positions    map[string]token.Position `json:"positions"`
+	RuntimeTests []string                  `json:"runtimeTests"`
+	Suites       *suiteIndex               `json:"suites"`
+	Diagnostics  []Diagnostic              `json:"diagnostics"`
+}
+
+// Discover all the test functions in given test files with their labels
+func discoverTestFuncs(testFiles []string) (*discovery, error) {
+	d := &discovery{
+		funcs:        map[string]TestLabels{},
+		runtimeTests: map[string]bool{},
+		suiteMethods: map[string]bool{},
+		positions:    map[string]token.Position{},
+	}
+	suites := newSuiteIndex()
+	fileDiscoveries, err := discoverFiles(newDiscoveryCache(), testFiles, runtime.GOMAXPROCS(0))
+	if err != nil {
+		return nil, err
+	}
+
+	// The files are merged in the given order, so the discovery is deterministic
+	for _, fd := range fileDiscoveries {
+		maps.Copy(d.funcs, fd.Funcs)
+		maps.Copy(d.positions, fd.Positions)
+		for _, name := range fd.RuntimeTests {
+			d.runtimeTests[name] = true
+		}
+		d.diagnostics = append(d.diagnostics, fd.Diagnostics...)
+		suites.merge(fd.Suites)
+	}
+
+	suites.addSuiteMethods(d)
+	return d, nil
+}
+
+// Discover the test functions in the test files by a bounded pool of workers. The results are in the order of the
+// files, and the error of the first failed file in the order is returned, regardless of which worker fails first.
+func discoverFiles(cache *discoveryCache, testFiles []string, workers int) ([]*fileDiscovery, error) {
+	results := make([]*fileDiscovery, len(testFiles))
+	errs := make([]error, len(testFiles))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(max(workers, 1), len(testFiles)) {
+		wg.Go(func() {
+			for i := range indexes {
+				results[i], errs[i] = cache.discoverFile(testFiles[i])
+			}
+		})
+	}
+	for i := range testFiles {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse %s, err: %v", file, err)
+			return nil, err
 		}
+	}
+	return results, nil
+}
 
-		for _, decl := range f.Decls {
-			fn, ok := decl.(*ast.FuncDecl)
-			if !ok || fn.Name == nil || !strings.HasPrefix(fn.Name.Name, "Test") {
-				continue
+// Discover the test functions in the test file source
+func discoverFile(file string, src []byte) (*fileDiscovery, error) {
+	fd := &fileDiscovery{
+		Funcs:     map[string]TestLabels{},
+		Positions: map[string]token.Position{},
+		Suites:    newSuiteIndex(),
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s, err: %v", file, err)
+	}
+	comments := newCommentIndex(fset, f, src)
+	runFunc := getRunFuncName(f)
+	suitePkg := getImportName(f, testifySuitePath)
+	derive := deriveLabelsEnabled()
+
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && suitePkg != "" {
+			fd.Suites.addTypeLabels(comments, gen)
+			continue
+		}
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name == nil || testFuncKind(fn.Name.Name) == "" {
+			continue
+		}
+
+		if suiteType := getSuiteMethodType(fn); suiteType != "" {
+			fd.Suites.addMethod(suiteType, fn.Name.Name, comments.labels(fn.Doc), fset.Position(fn.Pos()))
+			continue
+		}
+
+		if !isValidTestFunc(f, fn) {
+			continue
+		}
+
+		// Examples without output comment are compiled but not run by go test
+		if testFuncKind(fn.Name.Name) == examplePrefix && !hasExampleOutput(f, fn) {
+			continue
+		}
+
+		labels := comments.labels(fn.Doc)
+		if derive {
+			for key, value := range getDerivedLabels(f, fn) {
+				if _, ok := labels[key]; !ok {
+					labels[key] = value
+				}
+			}
+		}
+		if testFuncKind(fn.Name.Name) == testPrefix {
+			subtests, positions, runtime := findSubtests(comments, runFunc, fn, labels)
+			maps.Copy(fd.Funcs, subtests)
+			maps.Copy(fd.Positions, positions)
+			if runtime {
+				fd.RuntimeTests = append(fd.RuntimeTests, fn.Name.Name)
+			}
+			if suitePkg != "" {
+				fd.Suites.addEntries(suitePkg, fn)
 			}
+		}
+
+		fd.Funcs[fn.Name.Name] = labels
+		fd.Positions[fn.Name.Name] = fset.Position(fn.Pos())
+	}
+	fd.Diagnostics = comments.diagnostics(f)
+	return fd, nil
+}
+
+// Get the local name of the imported package in the file, it's empty if the package is not imported
+func getImportName(f *ast.File, path string) string {
+	for _, spec := range f.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err != nil || p != path {
+			continue
+		}
+		if spec.Name == nil {
+			return path[strings.LastIndex(path, "/")+1:]
+		}
+		if spec.Name.Name != "_" {
+			return spec.Name.Name
+		}
+	}
+	return ""
+}
+
+// Get the receiver type of a testify suite method like `func (s *MySuite) TestCreate()`, it's empty if
+// the function is not a suite method.
+func getSuiteMethodType(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) != 1 || testFuncKind(fn.Name.Name) != testPrefix {
+		return ""
+	}
+	if len(fn.Type.Params.List) != 0 || fn.Type.Results != nil {
+		return ""
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	if ident, ok := recv.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// Merge the suites found in another test file of the same package
+func (s *suiteIndex) merge(other *suiteIndex) {
+	for suiteType, entries := range other.Entries {
+		s.Entries[suiteType] = append(s.Entries[suiteType], entries...)
+	}
+	maps.Copy(s.TypeLabels, other.TypeLabels)
+	for suiteType, methods := range other.Methods {
+		if s.Methods[suiteType] == nil {
+			s.Methods[suiteType] = map[string]TestLabels{}
+		}
+		maps.Copy(s.Methods[suiteType], methods)
+	}
+	maps.Copy(s.Positions, other.Positions)
+}
+
+// Get the labels of type declarations, which are applied to the methods if the types are suites
+func (s *suiteIndex) addTypeLabels(comments *commentIndex, gen *ast.GenDecl) {
+	if gen.Tok != token.TYPE {
+		return
+	}
+	for _, spec := range gen.Specs {
+		typeSpec := spec.(*ast.TypeSpec)
+		doc := typeSpec.Doc
+		if doc == nil && len(gen.Specs) == 1 {
+			doc = gen.Doc
+		}
+		if labels := comments.labels(doc); len(labels) > 0 {
+			s.TypeLabels[typeSpec.Name.Name] = labels
+		}
+	}
+}
+
+func (s *suiteIndex) addMethod(suiteType string, method string, labels TestLabels, pos token.Position) {
+	if s.Methods[suiteType] == nil {
+		s.Methods[suiteType] = map[string]TestLabels{}
+	}
+	s.Methods[suiteType][method] = labels
+	s.Positions[suiteType+"."+method] = pos
+}
+
+// Find the suites run by the test function via `suite.Run(t, new(MySuite))` or `suite.Run(t, &MySuite{})`
+func (s *suiteIndex) addEntries(suitePkg string, fn *ast.FuncDecl) {
+	if fn.Body == nil {
+		return
+	}
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Run" {
+			return true
+		}
+		if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != suitePkg {
+			return true
+		}
+		if suiteType := getSuiteType(call.Args[1]); suiteType != "" {
+			s.Entries[suiteType] = append(s.Entries[suiteType], fn.Name.Name)
+		}
+		return true
+	})
+}
 
-			if !isValidTestFunc(fn) {
-				continue
+// Get the suite type name from `new(MySuite)`, `&MySuite{}` or `MySuite{}`
+func getSuiteType(expr ast.Expr) string {
+	if call, ok := expr.(*ast.CallExpr); ok {
+		if fun, ok := call.Fun.(*ast.Ident); ok && fun.Name == "new" && len(call.Args) == 1 {
+			if ident, ok := call.Args[0].(*ast.Ident); ok {
+				return ident.Name
 			}
+		}
+		return ""
+	}
+	if unary, ok := expr.(*ast.UnaryExpr); ok && unary.Op == token.AND {
+		expr = unary.X
+	}
+	if lit, ok := expr.(*ast.CompositeLit); ok {
+		if ident, ok := lit.Type.(*ast.Ident); ok {
+			return ident.Name
+		}
+	}
+	return ""
+}
+
+// Add the suite methods as the subtests of their entry tests, with the labels of the entry test, the suite type
+// and the method.
+func (s *suiteIndex) addSuiteMethods(d *discovery) {
+	for suiteType, entries := range s.Entries {
+		for _, entry := range entries {
+			for method, methodLabels := range s.Methods[suiteType] {
+				labels := maps.Clone(d.funcs[entry])
+				if labels == nil {
+					labels = make(TestLabels)
+				}
+				maps.Copy(labels, s.TypeLabels[suiteType])
+				maps.Copy(labels, methodLabels)
 
-			labels := getFuncLabels(fn)
-			if !Evaluate(filterAST, labels) {
-				continue
+				name := entry + "/" + method
+				d.funcs[name] = labels
+				d.suiteMethods[name] = true
+				d.positions[name] = s.Positions[suiteType+"."+method]
 			}
+		}
+	}
+}
 
-			testFuncs[fn.Name.Name] = labels
+// Get the kind of the test function by its name prefix, it's empty if the function is not run by go test
+func testFuncKind(name string) string {
+	for _, prefix := range []string{testPrefix, benchmarkPrefix, fuzzPrefix, examplePrefix} {
+		if strings.HasPrefix(name, prefix) {
+			return prefix
 		}
 	}
-	return testFuncs, nil
+	return ""
 }
 
-// Check function signature is func Test*(t *testing.T)
-func isValidTestFunc(fn *ast.FuncDecl) bool {
-	if len(fn.Type.Params.List) != 1 {
+// Check the function is run by go test: it's named like TestXxx, where Xxx doesn't start with a lowercase letter,
+// has no receiver nor type parameters, and its signature is func Test*(t *testing.T), func Benchmark*(b *testing.B),
+// func Fuzz*(f *testing.F) or func Example*(). The testing package is resolved through the imports of the file,
+// so it can be imported with an alias or dot-imported.
+func isValidTestFunc(f *ast.File, fn *ast.FuncDecl) bool {
+	kind := testFuncKind(fn.Name.Name)
+	if !isTestName(fn.Name.Name, kind) || fn.Recv != nil || fn.Type.TypeParams != nil || fn.Type.Results != nil {
+		return false
+	}
+	if kind == examplePrefix {
+		return len(fn.Type.Params.List) == 0
+	}
+	if len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) > 1 {
 		return false
 	}
 	param, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
+	return ok && isTestingName(f, param.X, testParamTypes[kind])
+}
+
+// Check the name has the prefix and the rest doesn't start with a lowercase letter, e.g. `Test`, `TestA` and
+// `Test_a` but not `Testa`, the same as go test.
+func isTestName(name string, prefix string) bool {
+	rest, ok := strings.CutPrefix(name, prefix)
 	if !ok {
 		return false
 	}
-	sel, ok := param.X.(*ast.SelectorExpr)
-	return ok && sel.Sel.Name == "T"
+	if rest == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return !unicode.IsLower(r)
+}
+
+// Check the expression names the identifier of the testing package, e.g. `testing.T`, `tt.T` if the package is
+// imported as tt or `T` if it's dot-imported.
+func isTestingName(f *ast.File, expr ast.Expr, name string) bool {
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != testingPath {
+			continue
+		}
+		switch {
+		case spec.Name == nil:
+			if isSelector(expr, testingPath, name) {
+				return true
+			}
+		case spec.Name.Name == ".":
+			if ident, ok := expr.(*ast.Ident); ok && ident.Name == name {
+				return true
+			}
+		case isSelector(expr, spec.Name.Name, name):
+			return true
+		}
+	}
+	return false
+}
+
+// Check the expression is `pkg.name`
+func isSelector(expr ast.Expr, pkg string, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	ident, ok := sel.X.(*ast.Ident)
+	return ok && ident.Name == pkg
+}
+
+// Check the example function has an output comment as the last comment in its body
+func hasExampleOutput(f *ast.File, fn *ast.FuncDecl) bool {
+	if fn.Body == nil {
+		return false
+	}
+	var last *ast.CommentGroup
+	for _, group := range f.Comments {
+		if group.Pos() > fn.Body.Lbrace && group.End() < fn.Body.Rbrace {
+			last = group
+		}
+	}
+	return last != nil && exampleOutputRegex.MatchString(last.Text())
+}
+
+// Derive the reserved labels from the test function and its file: `parallel=true` if it calls t.Parallel() on its
+// own parameter, `short-aware=true` if it checks testing.Short() and `net=true` if the file imports net/http,
+// database/sql or net. The t.Parallel() calls of the subtests in function literals don't count.
+func getDerivedLabels(f *ast.File, fn *ast.FuncDecl) TestLabels {
+	labels := make(TestLabels)
+	for _, path := range netImportPaths {
+		if getImportName(f, path) != "" {
+			labels[netLabel] = DefaultLabelValue
+		}
+	}
+	if fn.Body == nil {
+		return labels
+	}
+
+	var param string
+	if params := fn.Type.Params.List; len(params) == 1 && len(params[0].Names) == 1 {
+		param = params[0].Names[0].Name
+	}
+	var inspect func(body *ast.BlockStmt, topLevel bool)
+	inspect = func(body *ast.BlockStmt, topLevel bool) {
+		ast.Inspect(body, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.FuncLit:
+				inspect(n.Body, false)
+				return false
+			case *ast.CallExpr:
+				if len(n.Args) != 0 {
+					return true
+				}
+				if isTestingName(f, n.Fun, "Short") {
+					labels[shortAwareLabel] = DefaultLabelValue
+				}
+				if topLevel && param != "" && param != "_" && isSelector(n.Fun, param, "Parallel") {
+					labels[parallelLabel] = DefaultLabelValue
+				}
+			}
+			return true
+		})
+	}
+	inspect(fn.Body, true)
+	return labels
+}
+
+// Split the functions into the ones selected by -test.run and the benchmarks selected by -test.bench.
+// The fuzz targets are kept with the tests since -test.run selects their seed corpus runs.
+func partitionBenchmarks(funcs map[string]TestLabels) (map[string]TestLabels, map[string]TestLabels) {
+	tests := make(map[string]TestLabels)
+	benchmarks := make(map[string]TestLabels)
+	for name, labels := range funcs {
+		if testFuncKind(name) == benchmarkPrefix {
+			benchmarks[name] = labels
+		} else {
+			tests[name] = labels
+		}
+	}
+	return tests, benchmarks
+}
+
+// Get the fuzz targets from the given functions
+func getFuzzTargets(funcs map[string]TestLabels) map[string]TestLabels {
+	targets := make(map[string]TestLabels)
+	for name, labels := range funcs {
+		if testFuncKind(name) == fuzzPrefix {
+			targets[name] = labels
+		}
+	}
+	return targets
+}
+
+// Select the test functions whose labels satisfy the label filter
+func filterTestFuncsByLabels(funcs map[string]TestLabels, filterAST Node) map[string]TestLabels {
+	matched := make(map[string]TestLabels)
+	for name, labels := range funcs {
+		if Evaluate(filterAST, labels) {
+			matched[name] = labels
+		}
+	}
+	return matched
 }
 
 func filterTestFuncs(funcs map[string]TestLabels, regex *regexp.Regexp) map[string]TestLabels {
@@ -114,29 +702,5 @@ func filterTestFuncs(funcs map[string]TestLabels, regex *regexp.Regexp) map[stri
 
 // Get the labels from the function comments
 func getFuncLabels(fn *ast.FuncDecl) TestLabels {
-	tags := make(TestLabels)
-	if fn.Doc == nil {
-		return tags
-	}
-	for _, comment := range fn.Doc.List {
-		text := strings.TrimSpace(comment.Text)
-		// For both styles in `// @key=value` and `/* @key=value */`
-		text = strings.TrimPrefix(text, "//")
-		text = strings.TrimPrefix(text, "/*")
-		text = strings.TrimSuffix(text, "*/")
-		text = strings.TrimSpace(text)
-
-		if strings.HasPrefix(text, "@") {
-			parts := strings.SplitN(text[1:], "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-				tags[key] = value
-			} else {
-				key := strings.TrimSpace(parts[0])
-				tags[key] = DefaultLabelValue
-			}
-		}
-	}
-	return tags
+	return getCommentLabels(fn.Doc)
 }
@@ -1,8 +1,17 @@
 package gotest_labels
 
 import (
+	"fmt"
 	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -133,3 +142,594 @@ func TestGetFuncLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestIsValidTestFunc(t *testing.T) {
+	tests := map[string]struct {
+		imports string
+		src     string
+		want    bool
+	}{
+		"test func": {
+			src:  "func TestA(t *testing.T) {}",
+			want: true,
+		},
+		"benchmark func": {
+			src:  "func BenchmarkA(b *testing.B) {}",
+			want: true,
+		},
+		"test func with testing.B": {
+			src:  "func TestA(b *testing.B) {}",
+			want: false,
+		},
+		"benchmark func with testing.T": {
+			src:  "func BenchmarkA(t *testing.T) {}",
+			want: false,
+		},
+		"no parameter": {
+			src:  "func TestA() {}",
+			want: false,
+		},
+		"example func": {
+			src:  "func ExampleA() {}",
+			want: true,
+		},
+		"example func with parameter": {
+			src:  "func ExampleA(t *testing.T) {}",
+			want: false,
+		},
+		"example func with result": {
+			src:  "func ExampleA() int { return 0 }",
+			want: false,
+		},
+		"non pointer parameter": {
+			src:  "func TestA(t testing.T) {}",
+			want: false,
+		},
+		"T of other package": {
+			src:  "func Testify(t *foo.T) {}",
+			want: false,
+		},
+		"T of other package under test name": {
+			src:  "func TestA(t *foo.T) {}",
+			want: false,
+		},
+		"lowercase after prefix": {
+			src:  "func Testa(t *testing.T) {}",
+			want: false,
+		},
+		"underscore after prefix": {
+			src:  "func Test_a(t *testing.T) {}",
+			want: true,
+		},
+		"prefix only": {
+			src:  "func Test(t *testing.T) {}",
+			want: true,
+		},
+		"lowercase example": {
+			src:  "func Examplea() {}",
+			want: false,
+		},
+		"receiver": {
+			src:  "func (s *S) TestA(t *testing.T) {}",
+			want: false,
+		},
+		"type parameters": {
+			src:  "func TestA[P any](t *testing.T) {}",
+			want: false,
+		},
+		"result": {
+			src:  "func TestA(t *testing.T) error { return nil }",
+			want: false,
+		},
+		"two parameter names": {
+			src:  "func TestA(t, u *testing.T) {}",
+			want: false,
+		},
+		"import alias": {
+			imports: `import tt "testing"`,
+			src:     "func TestA(t *tt.T) {}",
+			want:    true,
+		},
+		"testing selector under import alias": {
+			imports: `import tt "testing"`,
+			src:     "func TestA(t *testing.T) {}",
+			want:    false,
+		},
+		"dot import": {
+			imports: `import . "testing"`,
+			src:     "func TestA(t *T) {}",
+			want:    true,
+		},
+		"unqualified T without dot import": {
+			src:  "func TestA(t *T) {}",
+			want: false,
+		},
+		"testing not imported": {
+			imports: `import "fmt"`,
+			src:     "func TestA(t *testing.T) {}",
+			want:    false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			imports := tt.imports
+			if imports == "" {
+				imports = `import "testing"`
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+imports+"\n"+tt.src, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fn := f.Decls[len(f.Decls)-1].(*ast.FuncDecl)
+			if got := isValidTestFunc(f, fn); got != tt.want {
+				t.Errorf("isValidTestFunc(%q) = %v, want %v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindTestFuncsWithExamples(t *testing.T) {
+	funcs, err := FindTestFuncs([]string{"./examples/docs/example_test.go"}, nil)
+	if err != nil {
+		t.Fatalf("FindTestFuncs() failed: %v", err)
+	}
+
+	if len(funcs) != 2 {
+		t.Errorf("Expected 2 runnable examples, got %v", funcs)
+	}
+	if funcs["ExampleFields"]["docs"] != "slow" {
+		t.Errorf("Expected ExampleFields with docs=slow, got %v", funcs["ExampleFields"])
+	}
+	if _, ok := funcs["ExampleRepeat"]; ok {
+		t.Errorf("Unexpected ExampleRepeat without output comment")
+	}
+}
+
+func TestDiscoverSuiteMethods(t *testing.T) {
+	d, err := discoverTestFuncs([]string{"./testdata/testify/suite_test.go"})
+	if err != nil {
+		t.Fatalf("discoverTestFuncs() failed: %v", err)
+	}
+
+	expected := map[string]TestLabels{
+		"TestAccountSuite":            {"env": "dev"},
+		"TestAccountSuite/TestCreate": {"env": "dev", "team": "accounts", "api": "create"},
+		"TestAccountSuite/TestDelete": {"env": "dev", "team": "accounts", "api": "delete", "slow": DefaultLabelValue},
+		"TestAccountSuite/TestList":   {"env": "dev", "team": "accounts"},
+		"TestOrderSuite":              {},
+		"TestOrderSuite/TestCreate":   {"api": "create"},
+	}
+	if len(d.funcs) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, d.funcs)
+	}
+	for name, labels := range expected {
+		if !maps.Equal(d.funcs[name], labels) {
+			t.Errorf("Expected %s with %v, got %v", name, labels, d.funcs[name])
+		}
+	}
+	if len(d.suiteMethods) != 4 || !d.suiteMethods["TestOrderSuite/TestCreate"] {
+		t.Errorf("Expected 4 suite methods, got %v", d.suiteMethods)
+	}
+}
+
+func TestGetBuildSettings(t *testing.T) {
+	info := &debug.BuildInfo{
+		Settings: []debug.BuildSetting{
+			{Key: "-compiler", Value: "gc"},
+			{Key: "-tags", Value: "integration,e2e"},
+			{Key: "CGO_ENABLED", Value: "0"},
+			{Key: "GOARCH", Value: "arm64"},
+			{Key: "GOOS", Value: "linux"},
+		},
+	}
+
+	t.Run("From build info", func(t *testing.T) {
+		buildFlags, env := getBuildSettings(info)
+		if !slices.Equal(buildFlags, []string{"-tags=integration,e2e"}) {
+			t.Errorf("Expected the build tags of the binary, got %v", buildFlags)
+		}
+		if !slices.Equal(env, []string{"CGO_ENABLED=0", "GOARCH=arm64", "GOOS=linux"}) {
+			t.Errorf("Expected the platform of the binary, got %v", env)
+		}
+	})
+
+	t.Run("Overridden by env", func(t *testing.T) {
+		t.Setenv("TEST_LABELS_TAGS", "smoke")
+
+		buildFlags, _ := getBuildSettings(info)
+		if !slices.Equal(buildFlags, []string{"-tags=smoke"}) {
+			t.Errorf("Expected the build tags from TEST_LABELS_TAGS, got %v", buildFlags)
+		}
+	})
+
+	t.Run("Implied build tags", func(t *testing.T) {
+		info := &debug.BuildInfo{
+			Settings: []debug.BuildSetting{
+				{Key: "-asan", Value: "false"},
+				{Key: "-race", Value: "true"},
+				{Key: "-tags", Value: "integration"},
+				{Key: "GOEXPERIMENT", Value: "rangefunc,noaliastypeparams"},
+			},
+		}
+		buildFlags, env := getBuildSettings(info)
+		if !slices.Equal(buildFlags, []string{"-race", "-tags=integration"}) {
+			t.Errorf("Expected the race flag and the build tags of the binary, got %v", buildFlags)
+		}
+		if !slices.Equal(env, []string{"GOEXPERIMENT=rangefunc,noaliastypeparams"}) {
+			t.Errorf("Expected the experiments of the binary, got %v", env)
+		}
+
+		ctx := getBuildContext(info)
+		if !slices.Equal(ctx.BuildTags, []string{"race", "integration"}) {
+			t.Errorf("Expected the race and integration build tags, got %v", ctx.BuildTags)
+		}
+		if !slices.Contains(ctx.ToolTags, "goexperiment.rangefunc") ||
+			slices.Contains(ctx.ToolTags, "goexperiment.aliastypeparams") {
+			t.Errorf("Expected the tool tags of the experiments, got %v", ctx.ToolTags)
+		}
+	})
+
+	t.Run("No build info", func(t *testing.T) {
+		buildFlags, env := getBuildSettings(nil)
+		if buildFlags != nil || env != nil {
+			t.Errorf("Expected no build settings, got %v and %v", buildFlags, env)
+		}
+	})
+}
+
+func TestFindTestFilesWithBuildTags(t *testing.T) {
+	const dir = "./examples/buildtags"
+	info, _ := debug.ReadBuildInfo()
+	findFuncs := map[string]func() (map[string][]string, error){
+		"packages.Load": func() (map[string][]string, error) {
+			pkgs, err := getPackages(".", dir, info)
+			return getTestFilesByDir(pkgs), err
+		},
+		"go/build": func() (map[string][]string, error) {
+			filesByDir, ok := listPackageTestFiles(dir, modulePath+"/examples/buildtags", info)
+			if !ok {
+				return nil, fmt.Errorf("failed to list %s", dir)
+			}
+			return filesByDir, nil
+		},
+	}
+
+	for name, find := range findFuncs {
+		for _, tags := range []string{"", "integration"} {
+			t.Run(name+"/tags="+tags, func(t *testing.T) {
+				t.Setenv("TEST_LABELS_TAGS", tags)
+
+				filesByDir, err := find()
+				if err != nil {
+					t.Fatalf("Failed to find test files: %v", err)
+				}
+				var names []string
+				for _, files := range filesByDir {
+					for _, file := range files {
+						names = append(names, filepath.Base(file))
+					}
+				}
+				slices.Sort(names)
+
+				want := []string{"unit_test.go"}
+				if tags != "" {
+					want = []string{"integration_test.go", "unit_test.go"}
+				}
+				if !slices.Equal(names, want) {
+					t.Errorf("Expected %v, got %v", want, names)
+				}
+			})
+		}
+	}
+}
+
+func TestListPackageTestFiles(t *testing.T) {
+	if got := getDirImportPath("./examples/simple"); got != modulePath+"/examples/simple" {
+		t.Errorf("getDirImportPath() = %q, want %q", got, modulePath+"/examples/simple")
+	}
+	if got := getDirImportPath(t.TempDir()); got != "" {
+		t.Errorf("getDirImportPath() out of module = %q, want empty", got)
+	}
+
+	// The directory is not the package of the pattern, it shall be loaded by go list
+	if _, ok := listPackageTestFiles("./examples/simple", modulePath+"/examples/bench", nil); ok {
+		t.Errorf("listPackageTestFiles() listed a directory of another package")
+	}
+	filesByDir, ok := listPackageTestFiles("./examples/simple", modulePath+"/examples/simple", nil)
+	if !ok || len(filesByDir) != 1 {
+		t.Errorf("listPackageTestFiles() = %v, %v", filesByDir, ok)
+	}
+}
+
+func TestReadModulePath(t *testing.T) {
+	tests := map[string]string{
+		"module example.com/demo\n\ngo 1.26\n":                "example.com/demo",
+		"// comment\nmodule \"example.com/quoted\" // note\n": "example.com/quoted",
+	}
+	for content, want := range tests {
+		goMod := filepath.Join(t.TempDir(), "go.mod")
+		if err := os.WriteFile(goMod, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := readModulePath(goMod); err != nil || got != want {
+			t.Errorf("readModulePath(%q) = %q, %v, want %q", content, got, err, want)
+		}
+	}
+}
+
+// The cost of finding the test files of one package per test binary, by go list and by go/build
+func BenchmarkFindTestFiles(b *testing.B) {
+	info, _ := debug.ReadBuildInfo()
+	b.Run("packages.Load", func(b *testing.B) {
+		for b.Loop() {
+			pkgs, err := getPackages(".", ".", info)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = getTestFilesByDir(pkgs)
+		}
+	})
+	b.Run("go/build", func(b *testing.B) {
+		for b.Loop() {
+			if _, ok := listPackageTestFiles(".", ".", info); !ok {
+				b.Fatal("failed to list the package")
+			}
+		}
+	})
+}
+
+// The cost of parsing the test files of one package with and without the object resolution
+func BenchmarkParseTestFiles(b *testing.B) {
+	files, err := filepath.Glob("*_test.go")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for name, mode := range map[string]parser.Mode{
+		"resolve objects":        parser.ParseComments,
+		"skip object resolution": parser.ParseComments | parser.SkipObjectResolution,
+	} {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				fset := token.NewFileSet()
+				for _, file := range files {
+					if _, err := parser.ParseFile(fset, file, nil, mode); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDiscoverTestFuncs(b *testing.B) {
+	files, err := filepath.Glob("*_test.go")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		if _, err := discoverTestFuncs(files); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDiscoverDerivedLabels(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		t.Setenv("TEST_LABELS_DERIVED", "")
+
+		d, err := discoverTestFuncs([]string{"./testdata/derived/derived_test.go"})
+		if err != nil {
+			t.Fatalf("discoverTestFuncs() failed: %v", err)
+		}
+		if !maps.Equal(d.funcs["TestParallel"], TestLabels{"group": "derived"}) {
+			t.Errorf("Expected no derived labels, got %v", d.funcs["TestParallel"])
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Setenv("TEST_LABELS_DERIVED", "true")
+
+		d, err := discoverTestFuncs([]string{"./testdata/derived/derived_test.go"})
+		if err != nil {
+			t.Fatalf("discoverTestFuncs() failed: %v", err)
+		}
+		expected := map[string]TestLabels{
+			"TestParallel": {"group": "derived", "parallel": "true", "net": "true"},
+			// The written label is not overridden
+			"TestShortAware":       {"group": "derived", "parallel": "false", "short-aware": "true", "net": "true"},
+			"TestParallelSubtests": {"group": "derived", "net": "true"},
+		}
+		for name, labels := range expected {
+			if !maps.Equal(d.funcs[name], labels) {
+				t.Errorf("Expected %s with %v, got %v", name, labels, d.funcs[name])
+			}
+		}
+	})
+}
+
+func TestGetPackagePattern(t *testing.T) {
+	testBinary := &debug.BuildInfo{Path: "example.com/pkg.test"}
+	tests := []struct {
+		name       string
+		defaultPkg string
+		recursive  string
+		info       *debug.BuildInfo
+		want       string
+	}{
+		{name: "package under test", info: testBinary, want: "example.com/pkg"},
+		{name: "no build info", want: "."},
+		{name: "command line files", info: &debug.BuildInfo{Path: "command-line-arguments.test"}, want: "."},
+		{name: "recursive", recursive: "true", info: testBinary, want: "./..."},
+		{name: "explicit pattern", defaultPkg: "./examples/simple", recursive: "true", info: testBinary, want: "./examples/simple"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origDefaultPkg := defaultPkg
+			defer func() { defaultPkg = origDefaultPkg }()
+			defaultPkg = tt.defaultPkg
+			t.Setenv("TEST_LABELS_RECURSIVE", tt.recursive)
+
+			if got := getPackagePattern(tt.info); got != tt.want {
+				t.Errorf("getPackagePattern() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSourceDir(t *testing.T) {
+	absSimple, err := filepath.Abs("./examples/simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		defaultPkg string
+		src        string
+		info       *debug.BuildInfo
+		want       string
+		wantErr    bool
+	}{
+		{name: "package directory", info: &debug.BuildInfo{Path: modulePath + ".test"}, want: "."},
+		{name: "no build info", want: "."},
+		{name: "explicit pattern", defaultPkg: "./examples/simple", info: &debug.BuildInfo{Path: "example.com/missing.test"}, want: "."},
+		{name: "explicit source", src: "./examples/simple", info: &debug.BuildInfo{Path: "example.com/missing.test"}, want: "./examples/simple"},
+		{name: "explicit source missing", src: "./examples/missing", wantErr: true},
+		{name: "located from build info", info: &debug.BuildInfo{Path: modulePath + "/examples/simple.test"}, want: absSimple},
+		{name: "not found", info: &debug.BuildInfo{Path: "example.com/missing.test"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origDefaultPkg := defaultPkg
+			defer func() { defaultPkg = origDefaultPkg }()
+			defaultPkg = tt.defaultPkg
+			t.Setenv("TEST_LABELS_SRC", tt.src)
+
+			got, err := findSourceDir(tt.info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findSourceDir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("findSourceDir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Write a synthetic package of generated test files, each with labeled tests and table-driven subtests
+func writeSyntheticPackage(tb testing.TB, files int, testsPerFile int) []string {
+	tb.Helper()
+
+	dir := tb.TempDir()
+	var paths []string
+	for i := range files {
+		var src strings.Builder
+		fmt.Fprintf(&src, "package synthetic\n\nimport \"testing\"\n")
+		for j := range testsPerFile {
+			fmt.Fprintf(&src, `
+// @group=g%d @file=f%d
+func TestGenerated%d_%d(t *testing.T) {
+	tests := []struct{ name string }{
+		{name: "eu"}, // @region=eu
+		{name: "us"}, // @region=us
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}
+`, j%5, i, i, j)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("gen%03d_test.go", i))
+		if err := os.WriteFile(path, []byte(src.String()), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestDiscoverFiles(t *testing.T) {
+	files := writeSyntheticPackage(t, 20, 3)
+	cache := &discoveryCache{}
+
+	sequential, err := discoverFiles(cache, files, 1)
+	if err != nil {
+		t.Fatalf("discoverFiles() failed: %v", err)
+	}
+	concurrent, err := discoverFiles(cache, files, 8)
+	if err != nil {
+		t.Fatalf("discoverFiles() failed: %v", err)
+	}
+	for i := range files {
+		if !maps.EqualFunc(sequential[i].Funcs, concurrent[i].Funcs, maps.Equal) {
+			t.Errorf("Expected the results of %s in order, got %v and %v", files[i], sequential[i].Funcs, concurrent[i].Funcs)
+		}
+	}
+
+	// The error of the first failed file in order is reported
+	broken := slices.Clone(files)
+	broken[5] = filepath.Join(t.TempDir(), "missing5_test.go")
+	broken[15] = filepath.Join(t.TempDir(), "missing15_test.go")
+	for range 10 {
+		_, err := discoverFiles(cache, broken, 8)
+		if err == nil || !strings.Contains(err.Error(), "missing5_test.go") {
+			t.Fatalf("Expected the error of missing5_test.go, got %v", err)
+		}
+	}
+}
+
+// The cost of discovering a large package of generated test files, sequentially and by the worker pool
+func BenchmarkDiscoverFiles(b *testing.B) {
+	files := writeSyntheticPackage(b, 300, 20)
+	cache := &discoveryCache{}
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for b.Loop() {
+				if _, err := discoverFiles(cache, files, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Only the comments the labels are read from are diagnosed, the prose with the marker elsewhere is not
+func TestDiscoverFileDiagnostics(t *testing.T) {
+	src := `package demo
+
+import "testing"
+
+// TODO(@alice): split the helper, ask @alice
+func helper() {}
+
+// @=demo
+func TestA(t *testing.T) {
+	// retry @ 5ms
+	helper()
+	t.Run("eu", func(t *testing.T) {}) // @region=eu @region=us
+}
+
+// TestB waits a bit, e.g. retry @ 5ms
+func TestB(t *testing.T) {}
+`
+	fd, err := discoverFile("demo_test.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range fd.Diagnostics {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"demo_test.go:8:4: empty label key",
+		`demo_test.go:12:51: duplicate label key "region"`,
+		`demo_test.go:15:34: label marker "@" is not followed by a key`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("discoverFile() diagnostics = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package gotest_labels
+
+import (
+	"maps"
+	"sync"
+)
+
+// The labels registered by Register in the current test binary
+var registry struct {
+	sync.Mutex
+	labels map[string]TestLabels
+}
+
+// Register labels the test or subtest of the given name, e.g. `TestGenerated` or `TestGenerated/case1`, for the
+// tests without hand-written doc comments like generated tests or tests assembled by frameworks. It shall be called
+// before MutateTestFilterByLabels runs, e.g. from an init function of a _test.go file along with calling
+// MutateTestFilterByLabels in TestMain.
+//
+// The registered labels take precedence over the labels of the same keys from comments and the manifest, and the
+// labels registered for the same test are merged.
+func Register(testName string, labels TestLabels) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.labels == nil {
+		registry.labels = make(map[string]TestLabels)
+	}
+	if registry.labels[testName] == nil {
+		registry.labels[testName] = make(TestLabels)
+	}
+	maps.Copy(registry.labels[testName], labels)
+}
+
+// RegistrySource is the LabelSource of the labels registered by Register. The registered tests which are not
+// discovered are labeled as well, e.g. the subtests generated at runtime.
+type RegistrySource struct{}
+
+func (RegistrySource) Name() string {
+	return "registry"
+}
+
+// BinaryScoped reports true since the labels are registered in the test binary
+func (RegistrySource) BinaryScoped() bool {
+	return true
+}
+
+func (RegistrySource) Labels(dir string, tests map[string]TestLabels) (map[string]TestLabels, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	labels := make(map[string]TestLabels, len(registry.labels))
+	for name, testLabels := range registry.labels {
+		labels[name] = maps.Clone(testLabels)
+	}
+	return labels, nil
+}
@@ -0,0 +1,81 @@
+package gotest_labels
+
+import (
+	"bytes"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegistrySource(t *testing.T) {
+	origLabels := registry.labels
+	defer func() { registry.labels = origLabels }()
+	registry.labels = nil
+
+	Register("TestGenerated", TestLabels{"group": "generated", "env": "prod"})
+	Register("TestGenerated", TestLabels{"owner": "me"})
+	Register("TestGenerated/case1", TestLabels{"slow": DefaultLabelValue})
+	Register("TestUnknown/case1", TestLabels{"group": "unknown"})
+
+	funcs := map[string]TestLabels{
+		"TestGenerated":    {"env": "dev", "region": "eu"},
+		"TestGenerated/us": {"env": "dev", "region": "us"},
+		"TestGenerated/qa": {"env": "qa", "region": "eu"},
+		"TestOther":        {"group": "other"},
+	}
+	if err := applyLabelSources([]LabelSource{RegistrySource{}}, "somewhere", funcs); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]TestLabels{
+		"TestGenerated":       {"group": "generated", "env": "prod", "owner": "me", "region": "eu"},
+		"TestGenerated/us":    {"group": "generated", "env": "prod", "owner": "me", "region": "us"},
+		"TestGenerated/qa":    {"group": "generated", "env": "qa", "owner": "me", "region": "eu"},
+		"TestGenerated/case1": {"group": "generated", "env": "prod", "owner": "me", "region": "eu", "slow": "true"},
+		"TestOther":           {"group": "other"},
+		"TestUnknown/case1":   {"group": "unknown"},
+	}
+	if len(funcs) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, funcs)
+	}
+	for name, labels := range expected {
+		if !maps.Equal(funcs[name], labels) {
+			t.Errorf("Expected %s with %v, got %v", name, labels, funcs[name])
+		}
+	}
+}
+
+// The registry is applied once to the tests discovered recursively, the registered tests not discovered aren't added
+// to each package directory, which would collide with each other.
+func TestRegistrySourceRecursive(t *testing.T) {
+	origLabels := registry.labels
+	defer func() { registry.labels = origLabels }()
+	registry.labels = nil
+	origDefaultPkg := defaultPkg
+	defer func() { defaultPkg = origDefaultPkg }()
+	// The package under test is discovered with its sub packages from its directory, like go test runs it
+	t.Chdir("./examples/simple")
+	defaultPkg = "./..."
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	Register("TestSimpleAlpha", TestLabels{"registered": DefaultLabelValue})
+	Register("TestGenerated/case1", TestLabels{"registered": DefaultLabelValue})
+
+	args := parseArgs([]string{"bin", "-labels", "registered=true"})
+	if args.err != nil {
+		t.Fatalf("parseArgs() error = %v", args.err)
+	}
+	selection := getTestFuncsByLabels(args)
+	if got := slices.Sorted(maps.Keys(selection.tests)); !slices.Equal(got, []string{"TestGenerated/case1", "TestSimpleAlpha"}) {
+		t.Errorf("Expected the registered tests to be selected, got %v", got)
+	}
+	if strings.Contains(logs.String(), "collides") {
+		t.Errorf("Expected no collision of the registered tests, got %s", logs.String())
+	}
+}
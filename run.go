@@ -0,0 +1,81 @@
+package gotest_labels
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The labels expression and the discovered labels of the current test binary, which are used to evaluate the
+// subtests labeled at runtime by Run.
+var runtimeLabels struct {
+	sync.Mutex
+	args      *cliArgs
+	testFuncs map[string]TestLabels // The discovered labels of tests and subtests
+	subtests  map[string]TestLabels // The labels of the subtests run by Run
+}
+
+func setRuntimeLabels(args *cliArgs, testFuncs map[string]TestLabels) {
+	runtimeLabels.Lock()
+	defer runtimeLabels.Unlock()
+	runtimeLabels.args = args
+	runtimeLabels.testFuncs = testFuncs
+}
+
+// Run runs fn as a subtest of t like t.Run, with the given labels. It's for the subtests whose names or labels
+// are only known at runtime. The labels are merged with the labels of the parent test and evaluated against the
+// active labels expression, the subtest is skipped if they don't match.
+//
+// The active labels expression is the one parsed by MutateTestFilterByLabels, or the TEST_LABELS env var if the
+// test package is not equipped. If no labels expression is set, the subtest always runs.
+func Run(t *testing.T, name string, labels TestLabels, fn func(t *testing.T)) bool {
+	t.Helper()
+	return t.Run(name, func(t *testing.T) {
+		subLabels := addRuntimeSubtest(t.Name(), labels)
+		labelsExp, labelsAST := getRuntimeLabelsExp()
+		if !Evaluate(labelsAST, subLabels) {
+			t.Skipf("Skipped by gotest-labels: labels %v don't match %q", subLabels, labelsExp)
+		}
+		fn(t)
+	})
+}
+
+// Record the labels of a subtest run by Run merged with the labels of its nearest labeled parent test
+func addRuntimeSubtest(name string, labels TestLabels) TestLabels {
+	runtimeLabels.Lock()
+	defer runtimeLabels.Unlock()
+
+	subLabels := make(TestLabels)
+	for parent := name; ; {
+		i := strings.LastIndex(parent, "/")
+		if i < 0 {
+			break
+		}
+		parent = parent[:i]
+		if parentLabels, ok := runtimeLabels.subtests[parent]; ok {
+			maps.Copy(subLabels, parentLabels)
+			break
+		}
+		if parentLabels, ok := runtimeLabels.testFuncs[parent]; ok {
+			maps.Copy(subLabels, parentLabels)
+			break
+		}
+	}
+	maps.Copy(subLabels, labels)
+
+	if runtimeLabels.subtests == nil {
+		runtimeLabels.subtests = make(map[string]TestLabels)
+	}
+	runtimeLabels.subtests[name] = subLabels
+	return subLabels
+}
+
+func getRuntimeLabelsExp() (string, Node) {
+	runtimeLabels.Lock()
+	defer runtimeLabels.Unlock()
+	if runtimeLabels.args == nil {
+		runtimeLabels.args = NewCliArgs()
+	}
+	return runtimeLabels.args.labels, runtimeLabels.args.labelsAST
+}
@@ -0,0 +1,49 @@
+package gotest_labels
+
+import (
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	origArgs, origTestFuncs := runtimeLabels.args, runtimeLabels.testFuncs
+	defer setRuntimeLabels(origArgs, origTestFuncs)
+
+	args := &cliArgs{labels: "region=eu&&team=platform"}
+	args.buildLabelsAST()
+	setRuntimeLabels(args, map[string]TestLabels{t.Name(): {"team": "platform"}})
+
+	ran := map[string]bool{}
+	for _, region := range []string{"eu", "us"} {
+		Run(t, region, TestLabels{"region": region}, func(t *testing.T) {
+			ran[region] = true
+			Run(t, "nested", nil, func(t *testing.T) {
+				ran[region+"/nested"] = true
+			})
+		})
+	}
+
+	if !ran["eu"] || !ran["eu/nested"] {
+		t.Errorf("Expected the eu subtests inheriting the parent labels to run, got %v", ran)
+	}
+	if ran["us"] || ran["us/nested"] {
+		t.Errorf("Expected the us subtests to be skipped, got %v", ran)
+	}
+
+	if labels := runtimeLabels.subtests[t.Name()+"/eu/nested"]; labels["region"] != "eu" || labels["team"] != "platform" {
+		t.Errorf("Expected nested subtest labels merged with parents, got %v", labels)
+	}
+}
+
+func TestRunWithoutLabels(t *testing.T) {
+	origArgs, origTestFuncs := runtimeLabels.args, runtimeLabels.testFuncs
+	defer setRuntimeLabels(origArgs, origTestFuncs)
+	setRuntimeLabels(&cliArgs{}, nil)
+
+	ran := false
+	Run(t, "any", TestLabels{"region": "us"}, func(t *testing.T) {
+		ran = true
+	})
+	if !ran {
+		t.Errorf("Expected the subtest to run without labels expression")
+	}
+}
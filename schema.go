@@ -0,0 +1,200 @@
+package gotest_labels
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SchemaFileName is the label schema checked in at the module root. It declares the label keys in use, their
+// allowed values or value types, and the keys every test must have:
+//
+//	{
+//	  "labels": {
+//	    "owner":    {"required": true},
+//	    "env":      {"values": ["dev", "staging", "prod"]},
+//	    "priority": {"type": "int"},
+//	    "slow":     {"type": "bool"}
+//	  }
+//	}
+//
+// The labels of discovered tests and the keys and values of the label expression are validated against it.
+const SchemaFileName = "testlabels.schema.json"
+
+// The value types of labels in the schema
+const (
+	labelTypeString = "string"
+	labelTypeBool   = "bool"
+	labelTypeInt    = "int"
+)
+
+// LabelSchema declares the allowed labels, any label key not declared is a violation
+type LabelSchema struct {
+	Labels map[string]LabelSpec `json:"labels"`
+}
+
+// LabelSpec declares the values allowed for a label key
+type LabelSpec struct {
+	Type     string   `json:"type,omitempty"`     // The value type, one of string, bool and int, string by default
+	Values   []string `json:"values,omitempty"`   // The allowed values, any value of the type if empty
+	Required bool     `json:"required,omitempty"` // Whether every test shall have the label
+}
+
+// LoadLabelSchema loads the label schema of the module containing the given directory.
+// A missing schema is not an error and returns nil, which allows any labels.
+func LoadLabelSchema(dir string) (*LabelSchema, error) {
+	root := findModuleRoot(dir)
+	if root == "" {
+		return nil, nil
+	}
+	path := filepath.Join(root, SchemaFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s, err: %v", path, err)
+	}
+
+	schema := &LabelSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, fmt.Errorf("failed to parse %s, err: %v", path, err)
+	}
+	for key, spec := range schema.Labels {
+		switch spec.Type {
+		case "", labelTypeString, labelTypeBool, labelTypeInt:
+		default:
+			return nil, fmt.Errorf("invalid type %q of label %s in %s", spec.Type, key, path)
+		}
+		for _, value := range spec.Values {
+			if !spec.matchesType(value) {
+				return nil, fmt.Errorf("value %q of label %s is not of type %s in %s", value, key, spec.Type, path)
+			}
+		}
+	}
+	return schema, nil
+}
+
+// Find the module root directory containing go.mod from the given directory upwards, it's empty if not found
+func findModuleRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func (s LabelSpec) matchesType(value string) bool {
+	var err error
+	switch s.Type {
+	case labelTypeBool:
+		_, err = strconv.ParseBool(value)
+	case labelTypeInt:
+		_, err = strconv.Atoi(value)
+	}
+	return err == nil
+}
+
+// Validate a label against the schema, it returns an empty string if the label is allowed
+func (s *LabelSchema) validateLabel(key string, value string) string {
+	spec, ok := s.Labels[key]
+	switch {
+	case !ok && isDerivedLabel(key):
+		// The derived labels are reserved, they're allowed unless the schema restricts them
+	case !ok:
+		return fmt.Sprintf("label %s is not declared in %s", key, SchemaFileName)
+	case len(spec.Values) > 0 && !slices.Contains(spec.Values, value):
+		return fmt.Sprintf("value %q of label %s is not one of %s", value, key, strings.Join(spec.Values, ", "))
+	case !spec.matchesType(value):
+		return fmt.Sprintf("value %q of label %s is not of type %s", value, key, spec.Type)
+	}
+	return ""
+}
+
+func isDerivedLabel(key string) bool {
+	return key == parallelLabel || key == shortAwareLabel || key == netLabel
+}
+
+// Validate the labels of the discovered tests against the schema. The subtests are only checked for the labels
+// they set or override, since they inherit the labels, including the required ones, from their parents.
+func (s *LabelSchema) validateTests(funcs map[string]TestLabels, positions map[string]token.Position) []Diagnostic {
+	if s == nil {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	for _, name := range slices.Sorted(maps.Keys(funcs)) {
+		labels := funcs[name]
+		parentLabels, isSubtest := getParentLabels(name, funcs)
+
+		addDiagnostic := func(message string) {
+			diagnostics = append(diagnostics, Diagnostic{
+				Pos:     positions[name],
+				Message: fmt.Sprintf("%s: %s", name, message),
+			})
+		}
+		for _, key := range slices.Sorted(maps.Keys(labels)) {
+			if value, ok := parentLabels[key]; ok && value == labels[key] {
+				continue
+			}
+			if message := s.validateLabel(key, labels[key]); message != "" {
+				addDiagnostic(message)
+			}
+		}
+		if isSubtest {
+			continue
+		}
+		for _, key := range slices.Sorted(maps.Keys(s.Labels)) {
+			if _, ok := labels[key]; s.Labels[key].Required && !ok {
+				addDiagnostic(fmt.Sprintf("required label %s is missing", key))
+			}
+		}
+	}
+	return diagnostics
+}
+
+// Get the labels of the closest discovered parent of a subtest, it returns false if the name is not a subtest
+func getParentLabels(name string, funcs map[string]TestLabels) (TestLabels, bool) {
+	for i := strings.LastIndex(name, "/"); i >= 0; i = strings.LastIndex(name[:i], "/") {
+		if labels, ok := funcs[name[:i]]; ok {
+			return labels, true
+		}
+	}
+	return nil, strings.Contains(name, "/")
+}
+
+// Validate the keys and values of the label expression against the schema
+func (s *LabelSchema) validateExp(node Node) error {
+	if s == nil {
+		return nil
+	}
+	switch n := node.(type) {
+	case Condition:
+		if message := s.validateLabel(n.Key, n.Value); message != "" {
+			return errors.New(message)
+		}
+	case LogicalOp:
+		for _, child := range n.Children {
+			if err := s.validateExp(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package gotest_labels
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testSchema = `{
+  "labels": {
+    "owner":    {"required": true},
+    "env":      {"values": ["dev", "prod"]},
+    "priority": {"type": "int"},
+    "region":   {}
+  }
+}`
+
+func writeSchemaModule(t *testing.T, schema string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module demo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, SchemaFileName), []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pkgDir := filepath.Join(root, "pkg")
+	if err := os.Mkdir(pkgDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return pkgDir
+}
+
+func TestLoadLabelSchema(t *testing.T) {
+	t.Run("Missing schema", func(t *testing.T) {
+		t.Parallel()
+
+		schema, err := LoadLabelSchema(t.TempDir())
+		if err != nil || schema != nil {
+			t.Errorf("LoadLabelSchema() = %v, %v, want nil schema and no error", schema, err)
+		}
+	})
+
+	t.Run("Schema at module root", func(t *testing.T) {
+		t.Parallel()
+
+		schema, err := LoadLabelSchema(writeSchemaModule(t, testSchema))
+		if err != nil {
+			t.Fatalf("LoadLabelSchema() error = %v", err)
+		}
+		if len(schema.Labels) != 4 || !schema.Labels["owner"].Required {
+			t.Errorf("LoadLabelSchema() = %+v", schema)
+		}
+	})
+
+	t.Run("Invalid type", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := LoadLabelSchema(writeSchemaModule(t, `{"labels": {"priority": {"type": "float"}}}`))
+		if err == nil {
+			t.Errorf("LoadLabelSchema() expected an error for invalid type")
+		}
+	})
+
+	t.Run("Value not of the type", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := LoadLabelSchema(writeSchemaModule(t, `{"labels": {"priority": {"type": "int", "values": ["high"]}}}`))
+		if err == nil {
+			t.Errorf("LoadLabelSchema() expected an error for value not of the type")
+		}
+	})
+}
+
+func TestValidateTests(t *testing.T) {
+	schema, err := LoadLabelSchema(writeSchemaModule(t, testSchema))
+	if err != nil {
+		t.Fatalf("LoadLabelSchema() error = %v", err)
+	}
+
+	funcs := map[string]TestLabels{
+		"TestA":            {"owner": "me", "env": "dev"},
+		"TestA/eu":         {"owner": "me", "env": "dev", "region": "eu"},
+		"TestA/eu/gold":    {"owner": "me", "env": "qa", "region": "eu"},
+		"TestB":            {"env": "stage", "priority": "high"},
+		"TestB/slow":       {"env": "stage", "priority": "high", "slow": "true"},
+		"TestC/unlabeled1": {"owner": "me"},
+		"TestD":            {"owner": "me", "parallel": "true", "net": "true"},
+	}
+	positions := map[string]token.Position{
+		"TestA/eu/gold": {Filename: "a_test.go", Line: 12, Column: 3},
+		"TestB":         {Filename: "b_test.go", Line: 5, Column: 1},
+		"TestB/slow":    {Filename: "b_test.go", Line: 8, Column: 3},
+	}
+
+	var got []string
+	for _, d := range schema.validateTests(funcs, positions) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`a_test.go:12:3: TestA/eu/gold: value "qa" of label env is not one of dev, prod`,
+		`b_test.go:5:1: TestB: value "stage" of label env is not one of dev, prod`,
+		`b_test.go:5:1: TestB: value "high" of label priority is not of type int`,
+		`b_test.go:5:1: TestB: required label owner is missing`,
+		`b_test.go:8:3: TestB/slow: label slow is not declared in testlabels.schema.json`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("validateTests() =\n%q\nwant\n%q", got, want)
+	}
+
+	var nilSchema *LabelSchema
+	if diagnostics := nilSchema.validateTests(funcs, positions); diagnostics != nil {
+		t.Errorf("validateTests() of nil schema = %v, want nil", diagnostics)
+	}
+}
+
+func TestParseLabelExpWithSchema(t *testing.T) {
+	schema, err := LoadLabelSchema(writeSchemaModule(t, testSchema))
+	if err != nil {
+		t.Fatalf("LoadLabelSchema() error = %v", err)
+	}
+
+	tests := map[string]bool{
+		"env=dev && owner=me":             true,
+		"!(env=prod) || priority=1":       true,
+		"env=qa":                          false,
+		"owner=me && (team=a || env=dev)": false,
+		"priority=high":                   false,
+	}
+	for exp, valid := range tests {
+		t.Run(exp, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseLabelExpWithSchema(exp, schema)
+			if (err == nil) != valid {
+				t.Errorf("ParseLabelExpWithSchema(%q) error = %v, want valid %v", exp, err, valid)
+			}
+			if _, err := ParseLabelExpWithSchema(exp, nil); err != nil {
+				t.Errorf("ParseLabelExpWithSchema(%q, nil) error = %v", exp, err)
+			}
+		})
+	}
+}
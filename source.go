@@ -0,0 +1,106 @@
+package gotest_labels
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// LabelSource provides the labels of tests from outside their comments, e.g. sidecar files, registries or the
+// export of a test-management system. The comments are the base source, which also discovers the tests.
+type LabelSource interface {
+	// Name identifies the source in the warnings and errors
+	Name() string
+	// Labels returns the labels by test names for the package directory. The discovered tests with the labels
+	// from the comments and the previous sources are given for look up, they shall not be modified.
+	Labels(dir string, tests map[string]TestLabels) (map[string]TestLabels, error)
+}
+
+// BinaryLabelSource is optionally implemented by a LabelSource whose labels belong to the test binary rather than a
+// package directory, e.g. the labels registered in the binary. In the recursive discovery, it's only applied to the
+// package under test rather than adding its tests to each package directory.
+type BinaryLabelSource interface {
+	LabelSource
+	// BinaryScoped reports whether the labels belong to the test binary
+	BinaryScoped() bool
+}
+
+// LabelSources are the label sources applied in order after the comments of each package directory. A source
+// takes precedence over the comments and the sources before it for the labels of the same keys. The labels of
+// a test are inherited by its subtests unless they set their own values, and the tests not discovered are added
+// with the labels of their closest discovered parents.
+//
+// The sources implementing BinaryLabelSource, e.g. the RegistrySource, only apply to the package under test.
+//
+// Custom sources can be added or the sources reordered before MutateTestFilterByLabels is called, e.g.
+//
+//	gotest_labels.LabelSources = append(gotest_labels.LabelSources, catalogSource{})
+var LabelSources = []LabelSource{ManifestSource{}, RegistrySource{}}
+
+// Check whether the labels of the source belong to the test binary rather than a package directory
+func isBinaryScoped(source LabelSource) bool {
+	binarySource, ok := source.(BinaryLabelSource)
+	return ok && binarySource.BinaryScoped()
+}
+
+// Get the label sources to apply to the package directory in order. The sources of the test binary only apply to
+// the package under test, and only they apply without the package source, e.g. to the embedded label table.
+func getDirLabelSources(sources []LabelSource, isPackage bool, hasSource bool) []LabelSource {
+	var dirSources []LabelSource
+	for _, source := range sources {
+		if binary := isBinaryScoped(source); (binary && isPackage) || (!binary && hasSource) {
+			dirSources = append(dirSources, source)
+		}
+	}
+	return dirSources
+}
+
+// Apply the label sources to the tests discovered in the package directory
+func applyLabelSources(sources []LabelSource, dir string, funcs map[string]TestLabels) error {
+	for _, source := range sources {
+		labels, err := source.Labels(dir, funcs)
+		if err != nil {
+			return fmt.Errorf("failed to get labels from %s, err: %v", source.Name(), err)
+		}
+		mergeLabels(funcs, labels, source.Name())
+	}
+	return nil
+}
+
+// Merge the labels of a source into the tests, the overridden labels of different values are warned.
+func mergeLabels(funcs map[string]TestLabels, labels map[string]TestLabels, sourceName string) {
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		testLabels, ok := funcs[name]
+		if !ok {
+			parentLabels, _ := getParentLabels(name, funcs)
+			testLabels = maps.Clone(parentLabels)
+			if testLabels == nil {
+				testLabels = make(TestLabels)
+			}
+			funcs[name] = testLabels
+		}
+		oldLabels := maps.Clone(testLabels)
+		for _, key := range slices.Sorted(maps.Keys(labels[name])) {
+			value := labels[name][key]
+			if old, ok := oldLabels[key]; ok && old != value {
+				log.Printf("Warning: %s overrides label %s=%s of %s with %s=%s",
+					sourceName, key, old, name, key, value)
+			}
+			testLabels[key] = value
+		}
+
+		for subtest, subLabels := range funcs {
+			if !strings.HasPrefix(subtest, name+"/") {
+				continue
+			}
+			for key, value := range labels[name] {
+				oldValue, inherited := oldLabels[key]
+				if subValue, ok := subLabels[key]; !ok || (inherited && subValue == oldValue) {
+					subLabels[key] = value
+				}
+			}
+		}
+	}
+}
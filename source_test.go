@@ -0,0 +1,113 @@
+package gotest_labels
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// A LabelSource of fixed labels, e.g. the export of a test-management system
+type staticSource struct {
+	name   string
+	labels map[string]TestLabels
+	err    error
+}
+
+func (s staticSource) Name() string {
+	return s.name
+}
+
+func (s staticSource) Labels(dir string, tests map[string]TestLabels) (map[string]TestLabels, error) {
+	return s.labels, s.err
+}
+
+func TestApplyLabelSources(t *testing.T) {
+	t.Run("Precedence", func(t *testing.T) {
+		t.Parallel()
+
+		funcs := map[string]TestLabels{
+			"TestA":    {"env": "dev", "owner": "me"},
+			"TestA/eu": {"env": "dev", "owner": "me", "region": "eu"},
+		}
+		sources := []LabelSource{
+			staticSource{name: "first", labels: map[string]TestLabels{
+				"TestA": {"env": "staging", "tier": "gold"},
+			}},
+			staticSource{name: "second", labels: map[string]TestLabels{
+				"TestA":    {"env": "prod"},
+				"TestA/us": {"region": "us"},
+			}},
+		}
+
+		if err := applyLabelSources(sources, "somewhere", funcs); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := map[string]TestLabels{
+			"TestA":    {"env": "prod", "owner": "me", "tier": "gold"},
+			"TestA/eu": {"env": "prod", "owner": "me", "tier": "gold", "region": "eu"},
+			"TestA/us": {"env": "prod", "owner": "me", "tier": "gold", "region": "us"},
+		}
+		if len(funcs) != len(expected) {
+			t.Errorf("Expected %v, got %v", expected, funcs)
+		}
+		for name, labels := range expected {
+			if !maps.Equal(funcs[name], labels) {
+				t.Errorf("Expected %s with %v, got %v", name, labels, funcs[name])
+			}
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		sources := []LabelSource{staticSource{name: "catalog", err: errors.New("unavailable")}}
+		err := applyLabelSources(sources, "somewhere", map[string]TestLabels{})
+		if err == nil || err.Error() != "failed to get labels from catalog, err: unavailable" {
+			t.Errorf("Expected the error of the catalog source, got %v", err)
+		}
+	})
+}
+
+func TestGetDirLabelSources(t *testing.T) {
+	catalog := staticSource{name: "catalog"}
+	sources := []LabelSource{ManifestSource{}, RegistrySource{}, catalog, &RegistrySource{}}
+
+	tests := []struct {
+		name      string
+		isPackage bool
+		hasSource bool
+		want      []LabelSource
+	}{
+		{name: "package under test", isPackage: true, hasSource: true, want: sources},
+		{name: "other package", hasSource: true, want: []LabelSource{ManifestSource{}, catalog}},
+		{name: "without source", isPackage: true, want: []LabelSource{RegistrySource{}, &RegistrySource{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getDirLabelSources(sources, tt.isPackage, tt.hasSource)
+			sameSource := func(a, b LabelSource) bool { return fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b) }
+			if !slices.EqualFunc(got, tt.want, sameSource) {
+				t.Errorf("getDirLabelSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The sources apply in order, the source after the registry overrides it
+	registry.Lock()
+	origLabels := registry.labels
+	registry.labels = map[string]TestLabels{"TestA": {"env": "dev"}}
+	registry.Unlock()
+	defer func() { registry.labels = origLabels }()
+
+	funcs := map[string]TestLabels{"TestA": {}}
+	catalog.labels = map[string]TestLabels{"TestA": {"env": "prod"}}
+	if err := applyLabelSources(getDirLabelSources([]LabelSource{RegistrySource{}, catalog}, true, true), ".", funcs); err != nil {
+		t.Fatal(err)
+	}
+	if funcs["TestA"]["env"] != "prod" {
+		t.Errorf("Expected the catalog to override the registry, got %v", funcs["TestA"])
+	}
+}
@@ -0,0 +1,367 @@
+package gotest_labels
+
+// subtest_parser.go finds the labeled subtests in the body of a test function. Two styles are supported:
+//
+//	// @region=eu
+//	t.Run("eu-region", func(t *testing.T) { ... })
+//
+// and the entries of table-driven tests, labeled by a comment or a `labels` field:
+//
+//	tests := []struct {
+//		name   string
+//		labels gotest_labels.TestLabels
+//	}{
+//		{name: "eu-region"}, // @region=eu
+//		{name: "us-region", labels: gotest_labels.TestLabels{"region": "us"}},
+//	}
+//
+// A table entry is named by its string `name` field, or by its string key in a map-based table, and it's
+// expected to be run as a subtest with that name. The subtests inherit the labels of their parent test and
+// they are named as go test reports them, e.g. `TestX/eu-region`.
+//
+// The subtests run by this package's Run are labeled by its labels argument. If the name or labels are
+// not literals, they are only known at runtime and the parent test is reported to be always run, so that
+// Run can evaluate the labels expression for the subtests.
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+const (
+	subtestNameField   = "name"
+	subtestLabelsField = "labels"
+
+	modulePath  = "github.com/maxwu/gotest-labels"
+	packageName = "gotest_labels"
+)
+
+// The comment groups of a file indexed by lines, to find the comments leading or trailing a node.
+type commentIndex struct {
+	fset     *token.FileSet
+	leading  map[int]*ast.CommentGroup  // The comment groups on their own lines by the end line
+	trailing map[int]*ast.CommentGroup  // The comment groups following code by the start line
+	read     map[*ast.CommentGroup]bool // The comment groups the labels are read from, which are diagnosed
+}
+
+func newCommentIndex(fset *token.FileSet, f *ast.File, src []byte) *commentIndex {
+	index := &commentIndex{
+		fset:     fset,
+		leading:  map[int]*ast.CommentGroup{},
+		trailing: map[int]*ast.CommentGroup{},
+		read:     map[*ast.CommentGroup]bool{},
+	}
+	for _, group := range f.Comments {
+		start := fset.Position(group.Pos())
+		lineStart := start.Offset - (start.Column - 1)
+		if lineStart >= 0 && len(bytes.TrimSpace(src[lineStart:start.Offset])) == 0 {
+			index.leading[fset.Position(group.End()).Line] = group
+		} else {
+			index.trailing[start.Line] = group
+		}
+	}
+	return index
+}
+
+// Get the labels from the comment group and record it as read, so only the comments in the places of labels are
+// diagnosed rather than any prose with the marker, e.g. `// retry @ 5ms`
+func (c *commentIndex) labels(group *ast.CommentGroup) TestLabels {
+	if group != nil {
+		c.read[group] = true
+	}
+	return getCommentLabels(group)
+}
+
+// Get the diagnostics of the comment groups the labels are read from, in the order of the file
+func (c *commentIndex) diagnostics(f *ast.File) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, group := range f.Comments {
+		if c.read[group] {
+			diagnostics = append(diagnostics, getCommentDiagnostics(c.fset, group)...)
+		}
+	}
+	return diagnostics
+}
+
+// Get the labels from the comment right above the node and the comment following it on its last line
+func (c *commentIndex) nodeLabels(node ast.Node) TestLabels {
+	labels := make(TestLabels)
+	if group, ok := c.leading[c.fset.Position(node.Pos()).Line-1]; ok {
+		maps.Copy(labels, c.labels(group))
+	}
+	if group, ok := c.trailing[c.fset.Position(node.End()).Line]; ok && group.Pos() >= node.End() {
+		maps.Copy(labels, c.labels(group))
+	}
+	return labels
+}
+
+type subtestParser struct {
+	comments  *commentIndex
+	runFunc   string // The name of this package's Run function in the file, empty if not imported
+	subtests  map[string]TestLabels
+	positions map[string]token.Position // The positions of the labeled subtests
+	runtime   bool                      // Whether there are subtests labeled at runtime
+}
+
+// Find the labeled subtests of the test function. It returns a map of the full subtest names to their labels
+// merged with the labels of their parents, their positions, and whether the test has subtests only labeled at runtime.
+func findSubtests(comments *commentIndex, runFunc string, fn *ast.FuncDecl,
+	labels TestLabels) (map[string]TestLabels, map[string]token.Position, bool) {
+	p := &subtestParser{
+		comments:  comments,
+		runFunc:   runFunc,
+		subtests:  map[string]TestLabels{},
+		positions: map[string]token.Position{},
+	}
+	if fn.Body != nil {
+		p.walk(fn.Body, fn.Name.Name, labels)
+	}
+	return p.subtests, p.positions, p.runtime
+}
+
+func (p *subtestParser) walk(body *ast.BlockStmt, parent string, labels TestLabels) {
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.CallExpr:
+			if p.isRunCall(n) {
+				p.addRunCall(n, parent, labels)
+				return false
+			}
+			name, fnLit, ok := subtestCall(n)
+			if !ok {
+				return true
+			}
+			fullName := parent + "/" + rewriteSubtestName(name)
+			subLabels := p.add(n, fullName, labels, p.comments.nodeLabels(n))
+			p.walk(fnLit.Body, fullName, subLabels)
+			return false
+		case *ast.CompositeLit:
+			p.addTableEntries(n, parent, labels)
+		}
+		return true
+	})
+}
+
+// Add the subtest declared by the node if it has its own labels, it returns the labels merged with the parent labels.
+func (p *subtestParser) add(node ast.Node, fullName string, parentLabels TestLabels, ownLabels TestLabels) TestLabels {
+	if len(ownLabels) == 0 {
+		return parentLabels
+	}
+	if _, ok := p.positions[fullName]; !ok {
+		p.positions[fullName] = p.comments.fset.Position(node.Pos())
+	}
+	labels := maps.Clone(parentLabels)
+	maps.Copy(labels, ownLabels)
+	if existing, ok := p.subtests[fullName]; ok {
+		maps.Copy(existing, labels)
+		return existing
+	}
+	p.subtests[fullName] = labels
+	return labels
+}
+
+// Add the subtest of `gotest_labels.Run(t, "name", TestLabels{...}, func(t *testing.T) {...})`
+func (p *subtestParser) addRunCall(call *ast.CallExpr, parent string, labels TestLabels) {
+	name, okName := stringLit(call.Args[1])
+	runLabels, okLabels := compositeLabels(call.Args[2])
+	if !okName || !okLabels {
+		p.runtime = true
+		return
+	}
+
+	fullName := parent + "/" + rewriteSubtestName(name)
+	ownLabels := p.comments.nodeLabels(call)
+	maps.Copy(ownLabels, runLabels)
+	subLabels := p.add(call, fullName, labels, ownLabels)
+	if fnLit, ok := call.Args[3].(*ast.FuncLit); ok {
+		p.walk(fnLit.Body, fullName, subLabels)
+	}
+}
+
+// Check the call is this package's Run function
+func (p *subtestParser) isRunCall(call *ast.CallExpr) bool {
+	if p.runFunc == "" || len(call.Args) != 4 {
+		return false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return p.runFunc == "Run" && fun.Name == "Run"
+	case *ast.SelectorExpr:
+		pkg, ok := fun.X.(*ast.Ident)
+		return ok && p.runFunc == pkg.Name+".Run" && fun.Sel.Name == "Run"
+	}
+	return false
+}
+
+// Get the name to call this package's Run function in the file, e.g. `gotest_labels.Run` or `Run` if dot imported
+func getRunFuncName(f *ast.File) string {
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != modulePath {
+			continue
+		}
+		switch {
+		case spec.Name == nil:
+			return packageName + ".Run"
+		case spec.Name.Name == ".":
+			return "Run"
+		case spec.Name.Name != "_":
+			return spec.Name.Name + ".Run"
+		}
+	}
+	return ""
+}
+
+// Add the labeled entries of a table-driven test
+func (p *subtestParser) addTableEntries(table *ast.CompositeLit, parent string, labels TestLabels) {
+	for _, elt := range table.Elts {
+		var name string
+		var entry *ast.CompositeLit
+
+		switch e := elt.(type) {
+		case *ast.KeyValueExpr:
+			// A map-based table keyed by the subtest names
+			key, ok := stringLit(e.Key)
+			value, isLit := e.Value.(*ast.CompositeLit)
+			if !ok || !isLit {
+				continue
+			}
+			name, entry = key, value
+		case *ast.CompositeLit:
+			name, entry = entryName(e), e
+		}
+		if name == "" {
+			continue
+		}
+
+		ownLabels := p.comments.nodeLabels(elt)
+		maps.Copy(ownLabels, entryLabels(entry))
+		p.add(elt, parent+"/"+rewriteSubtestName(name), labels, ownLabels)
+	}
+}
+
+// Check the call is `t.Run("name", func(t *testing.T) {...})`, it returns the subtest name and function
+func subtestCall(call *ast.CallExpr) (string, *ast.FuncLit, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Run" || len(call.Args) != 2 {
+		return "", nil, false
+	}
+	name, ok := stringLit(call.Args[0])
+	if !ok {
+		return "", nil, false
+	}
+	fnLit, ok := call.Args[1].(*ast.FuncLit)
+	if !ok || len(fnLit.Type.Params.List) != 1 {
+		return "", nil, false
+	}
+	return name, fnLit, true
+}
+
+// Get the string `name` field of a table entry
+func entryName(entry *ast.CompositeLit) string {
+	for _, elt := range entry.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok || !isFieldKey(kv.Key, subtestNameField) {
+			continue
+		}
+		if name, ok := stringLit(kv.Value); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Get the labels from the `labels` field of a table entry, e.g. `labels: TestLabels{"region": "eu"}`
+func entryLabels(entry *ast.CompositeLit) TestLabels {
+	labels := make(TestLabels)
+	for _, elt := range entry.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok || !isFieldKey(kv.Key, subtestLabelsField) {
+			continue
+		}
+		if fieldLabels, ok := compositeLabels(kv.Value); ok {
+			maps.Copy(labels, fieldLabels)
+		}
+	}
+	return labels
+}
+
+// Get the labels from a literal like `TestLabels{"region": "eu"}` or `nil`, it returns false if the labels
+// are not literals.
+func compositeLabels(expr ast.Expr) (TestLabels, bool) {
+	labels := make(TestLabels)
+	if ident, ok := expr.(*ast.Ident); ok && ident.Name == "nil" {
+		return labels, true
+	}
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return nil, false
+	}
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, false
+		}
+		key, okKey := stringLit(kv.Key)
+		value, okValue := stringLit(kv.Value)
+		if !okKey || !okValue {
+			return nil, false
+		}
+		labels[key] = value
+	}
+	return labels, true
+}
+
+func isFieldKey(expr ast.Expr, field string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && strings.EqualFold(ident.Name, field)
+}
+
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	return value, err == nil
+}
+
+// Rewrite the subtest name the same way as the testing package, i.e. spaces are replaced by underscores
+// and non-printable characters are escaped.
+func rewriteSubtestName(s string) string {
+	b := []byte{}
+	for _, r := range s {
+		switch {
+		case isSpace(r):
+			b = append(b, '_')
+		case !strconv.IsPrint(r):
+			s := strconv.QuoteRune(r)
+			b = append(b, s[1:len(s)-1]...)
+		default:
+			b = append(b, string(r)...)
+		}
+	}
+	return string(b)
+}
+
+// The white spaces recognized by the testing package, which are not the same as the Unicode Z class.
+func isSpace(r rune) bool {
+	if r < 0x2000 {
+		switch r {
+		case '\t', '\n', '\v', '\f', '\r', ' ', 0x85, 0xA0, 0x1680:
+			return true
+		}
+	} else {
+		if r <= 0x200a {
+			return true
+		}
+		switch r {
+		case 0x2028, 0x2029, 0x202f, 0x205f, 0x3000:
+			return true
+		}
+	}
+	return false
+}
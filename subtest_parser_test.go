@@ -0,0 +1,141 @@
+package gotest_labels
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"testing"
+)
+
+const subtestSrc = `package p
+
+import (
+	"testing"
+
+	labels "github.com/maxwu/gotest-labels"
+)
+
+func TestTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+	}{
+		// @region=eu
+		{name: "eu region"},
+		{name: "us-region", labels: map[string]string{"region": "us"}},
+		{name: "ap-region"}, // @region=ap @slow
+		{name: "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}
+
+func TestMapTable(t *testing.T) {
+	tests := map[string]struct{ want int }{
+		// @region=eu
+		"eu": {want: 1},
+		"us": {want: 2},
+	}
+	_ = tests
+}
+
+func TestRun(t *testing.T) {
+	// @region=eu
+	t.Run("eu", func(t *testing.T) {
+		// @tier=gold
+		t.Run("gold", func(t *testing.T) {})
+		t.Run("silver", func(t *testing.T) {})
+	})
+	t.Run("us", func(t *testing.T) {
+		// @tier=gold
+		t.Run("gold", func(t *testing.T) {})
+	})
+}
+
+func TestRunLiteral(t *testing.T) {
+	labels.Run(t, "eu", labels.TestLabels{"region": "eu"}, func(t *testing.T) {
+		// @tier=gold
+		t.Run("gold", func(t *testing.T) {})
+	})
+}
+
+func TestRunRuntime(t *testing.T) {
+	for _, region := range []string{"eu", "us"} {
+		labels.Run(t, region, labels.TestLabels{"region": region}, func(t *testing.T) {})
+	}
+}
+`
+
+func TestFindSubtests(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "subtest_test.go", subtestSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := newCommentIndex(fset, f, []byte(subtestSrc))
+	runFunc := getRunFuncName(f)
+	if runFunc != "labels.Run" {
+		t.Errorf("getRunFuncName() = %q, want labels.Run", runFunc)
+	}
+
+	tests := map[string]map[string]TestLabels{
+		"TestTable": {
+			"TestTable/eu_region": {"owner": "me", "region": "eu"},
+			"TestTable/us-region": {"owner": "me", "region": "us"},
+			"TestTable/ap-region": {"owner": "me", "region": "ap", "slow": DefaultLabelValue},
+		},
+		"TestMapTable": {
+			"TestMapTable/eu": {"owner": "me", "region": "eu"},
+		},
+		"TestRunLiteral": {
+			"TestRunLiteral/eu":      {"owner": "me", "region": "eu"},
+			"TestRunLiteral/eu/gold": {"owner": "me", "region": "eu", "tier": "gold"},
+		},
+		"TestRunRuntime": {},
+		"TestRun": {
+			"TestRun/eu":      {"owner": "me", "region": "eu"},
+			"TestRun/eu/gold": {"owner": "me", "region": "eu", "tier": "gold"},
+			"TestRun/us/gold": {"owner": "me", "tier": "gold"},
+		},
+	}
+
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		t.Run(fn.Name.Name, func(t *testing.T) {
+			want := tests[fn.Name.Name]
+			got, positions, runtime := findSubtests(comments, runFunc, fn, TestLabels{"owner": "me"})
+			if runtime != (fn.Name.Name == "TestRunRuntime") {
+				t.Errorf("findSubtests() runtime = %v for %s", runtime, fn.Name.Name)
+			}
+			if len(got) != len(want) {
+				t.Errorf("findSubtests() = %v, want %v", got, want)
+			}
+			for name, labels := range want {
+				if !maps.Equal(got[name], labels) {
+					t.Errorf("findSubtests()[%q] = %v, want %v", name, got[name], labels)
+				}
+				if positions[name].Line == 0 {
+					t.Errorf("findSubtests() has no position of %q", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteSubtestName(t *testing.T) {
+	tests := map[string]string{
+		"eu-region":    "eu-region",
+		"eu region":    "eu_region",
+		"tab\tand\x00": `tab_and\x00`,
+	}
+	for name, want := range tests {
+		if got := rewriteSubtestName(name); got != want {
+			t.Errorf("rewriteSubtestName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package gotest_labels
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SourceDir is the source directory of the package under test, for the test binaries run outside of it, e.g.
+// `./pkg.test` built by `go test -c`. The TEST_LABELS_SRC env variable sets it as well. The package pattern of the
+// discovery is resolved from it instead of the current directory.
+var SourceDir = ""
+
+// The error of a test binary whose package source is not found, the test filter can't be mutated without it
+type sourceNotFoundError struct {
+	pkgPath string // The import path of the package under test
+	dir     string // The current directory
+}
+
+func (e *sourceNotFoundError) Error() string {
+	return fmt.Sprintf("the source of the test package %s is not found from %s, run the test binary in its "+
+		"package directory, set TEST_LABELS_SRC to the package directory, or embed the labels with go generate",
+		e.pkgPath, e.dir)
+}
+
+// Find the test files to discover by their directories, from the source directory found by findSourceDir. The package
+// under test is listed from its directory by go/build, which evaluates the build constraints the same way as go test
+// without running any command. The go list command behind packages.Load only runs for the recursive discovery, an
+// explicit package pattern, or if the current directory is not the package under test.
+func findTestFiles(dir string) (map[string][]string, error) {
+	info, _ := debug.ReadBuildInfo()
+	pattern := getPackagePattern(info)
+	if filesByDir, ok := listPackageTestFiles(dir, pattern, info); ok {
+		return filesByDir, nil
+	}
+
+	pkgs, err := getPackages(dir, pattern, info)
+	if err != nil {
+		return nil, err
+	}
+	return getTestFilesByDir(pkgs), nil
+}
+
+// Find the source directory of the package under test to resolve the package pattern from. go test runs the test
+// binary in the package directory, a test binary run directly elsewhere locates its package from the import path in
+// the build info, e.g. in the module of the current directory. If the package isn't found, it's an error rather than
+// discovering the unrelated tests of the current directory.
+func findSourceDir(info *debug.BuildInfo) (string, error) {
+	if defaultPkg != "" {
+		return ".", nil
+	}
+	if dir := getSourceDir(); dir != "" {
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			return "", fmt.Errorf("TEST_LABELS_SRC %s is not a directory", dir)
+		}
+		return dir, nil
+	}
+
+	pkgPath := getTestPackagePath(info)
+	if pkgPath == "" || getDirImportPath(".") == pkgPath {
+		return ".", nil
+	}
+	if pkgs, err := getPackages(".", pkgPath, info); err == nil {
+		for _, pkg := range pkgs {
+			if pkg.PkgPath == pkgPath && len(pkg.GoFiles) > 0 {
+				return filepath.Dir(pkg.GoFiles[0]), nil
+			}
+		}
+	}
+	cwd, _ := os.Getwd()
+	return "", &sourceNotFoundError{pkgPath: pkgPath, dir: cwd}
+}
+
+func getSourceDir() string {
+	if dir := os.Getenv("TEST_LABELS_SRC"); dir != "" {
+		return dir
+	}
+	return SourceDir
+}
+
+// List the *_test.go files of the package in the directory with go/build, it returns false if the directory is not
+// the package of the pattern or it can't be listed, so the packages shall be loaded by go list.
+func listPackageTestFiles(dir string, pattern string, info *debug.BuildInfo) (map[string][]string, bool) {
+	if pattern != "." && pattern != getDirImportPath(dir) {
+		return nil, false
+	}
+	pkg, err := getBuildContext(info).ImportDir(dir, 0)
+	if err != nil {
+		return nil, false
+	}
+
+	var files []string
+	for _, file := range append(pkg.TestGoFiles, pkg.XTestGoFiles...) {
+		files = append(files, filepath.Join(pkg.Dir, file))
+	}
+	if len(files) == 0 {
+		return map[string][]string{}, true
+	}
+	return map[string][]string{pkg.Dir: files}, true
+}
+
+// Get the go/build context of the build tags and target platform of the test binary, the same as the packages
+// loaded by go list.
+func getBuildContext(info *debug.BuildInfo) *build.Context {
+	ctx := build.Default
+	buildFlags, env := getBuildSettings(info)
+	for _, flag := range buildFlags {
+		if tags, ok := strings.CutPrefix(flag, "-tags="); ok {
+			ctx.BuildTags = append(ctx.BuildTags, strings.Split(tags, ",")...)
+		} else {
+			// The -race, -msan and -asan flags imply the build tags of the same names
+			ctx.BuildTags = append(ctx.BuildTags, strings.TrimPrefix(flag, "-"))
+		}
+	}
+	for _, setting := range env {
+		key, value, _ := strings.Cut(setting, "=")
+		switch key {
+		case "GOOS":
+			ctx.GOOS = value
+		case "GOARCH":
+			ctx.GOARCH = value
+		case "CGO_ENABLED":
+			ctx.CgoEnabled, _ = strconv.ParseBool(value)
+		case "GOEXPERIMENT":
+			ctx.ToolTags = applyExperiments(ctx.ToolTags, value)
+		}
+	}
+	return &ctx
+}
+
+// Apply the GOEXPERIMENT setting of the binary to the tool tags, e.g. `rangefunc,noswissmap` adds the
+// goexperiment.rangefunc tag and removes the goexperiment.swissmap tag enabled by default.
+func applyExperiments(toolTags []string, experiments string) []string {
+	toolTags = slices.Clone(toolTags)
+	for _, experiment := range strings.Split(experiments, ",") {
+		name, disabled := strings.CutPrefix(experiment, "no")
+		if name == "" {
+			continue
+		}
+		tag := "goexperiment." + name
+		toolTags = slices.DeleteFunc(toolTags, func(t string) bool { return t == tag })
+		if !disabled {
+			toolTags = append(toolTags, tag)
+		}
+	}
+	return toolTags
+}
+
+// Check whether the paths are the same directory, e.g. "." and the absolute path of the current directory
+func isSameDir(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// Get the import path of the package in the directory from the module path in go.mod, it's empty if the directory
+// is not in a module.
+func getDirImportPath(dir string) string {
+	root := findModuleRoot(dir)
+	if root == "" {
+		return ""
+	}
+	modulePath, err := readModulePath(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(root, absDir)
+	if err != nil {
+		return ""
+	}
+	if rel == "." {
+		return modulePath
+	}
+	return modulePath + "/" + filepath.ToSlash(rel)
+}
+
+// Read the module path from the module directive of go.mod
+func readModulePath(goMod string) (string, error) {
+	f, err := os.Open(goMod)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+		if path, err := strconv.Unquote(fields[1]); err == nil {
+			return path, nil
+		}
+		return fields[1], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no module directive in %s", goMod)
+}
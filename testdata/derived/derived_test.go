@@ -0,0 +1,27 @@
+package derived
+
+import (
+	"net/http"
+	. "testing"
+)
+
+// @group=derived
+func TestParallel(t *T) {
+	t.Parallel()
+	_ = http.MethodGet
+}
+
+// @group=derived @parallel=false
+func TestShortAware(t *T) {
+	if Short() {
+		t.Skip("skipped in short mode")
+	}
+	t.Parallel()
+}
+
+// @group=derived
+func TestParallelSubtests(t *T) {
+	t.Run("sub", func(t *T) {
+		t.Parallel()
+	})
+}
@@ -0,0 +1,39 @@
+package testify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// @team=accounts
+type AccountSuite struct {
+	suite.Suite
+}
+
+// @api=create
+func (s *AccountSuite) TestCreate() {}
+
+// @api=delete @slow
+func (s *AccountSuite) TestDelete() {}
+
+func (s *AccountSuite) TestList() {}
+
+// A helper method is not a test
+func (s *AccountSuite) SetupTest() {}
+
+// @env=dev
+func TestAccountSuite(t *testing.T) {
+	suite.Run(t, new(AccountSuite))
+}
+
+type OrderSuite struct {
+	suite.Suite
+}
+
+// @api=create
+func (s OrderSuite) TestCreate() {}
+
+func TestOrderSuite(t *testing.T) {
+	suite.Run(t, &OrderSuite{})
+}
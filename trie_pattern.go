@@ -0,0 +1,116 @@
+package gotest_labels
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Build the regex matching exactly the given names, factored by their shared prefixes like a trie, e.g.
+// `Test(Api(Create|Delete)|Db)` of TestApiCreate, TestApiDelete and TestDb. The factored pattern grows with the
+// distinct parts of the names instead of their whole length, so the pattern of thousands of tests stays within the
+// argument length limits and is faster to match than a flat alternation. The pattern is not anchored.
+func buildTriePattern(names []string) string {
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+	if len(names) == 0 {
+		return ""
+	}
+	return buildTrieNode(names)
+}
+
+// Build the pattern of the sorted and distinct names, one of which may be empty
+func buildTrieNode(names []string) string {
+	if len(names) == 1 {
+		return regexp.QuoteMeta(names[0])
+	}
+
+	if prefix := commonPrefix(names[0], names[len(names)-1]); prefix != "" {
+		rest := make([]string, len(names))
+		for i, name := range names {
+			rest[i] = name[len(prefix):]
+		}
+		return regexp.QuoteMeta(prefix) + buildTrieNode(rest)
+	}
+
+	// The names are branched by their first rune, the empty name makes the branches optional.
+	// The sorted names of the same first rune are adjacent, the single runes are merged into a character class.
+	optional := names[0] == ""
+	if optional {
+		names = names[1:]
+	}
+	var runes []rune
+	var branches []string
+	for i := 0; i < len(names); {
+		r, size := utf8.DecodeRuneInString(names[i])
+		j := i + 1
+		for j < len(names) && strings.HasPrefix(names[j], names[i][:size]) {
+			j++
+		}
+		if j == i+1 && len(names[i]) == size && r != utf8.RuneError {
+			runes = append(runes, r)
+		} else {
+			branches = append(branches, buildTrieNode(names[i:j]))
+		}
+		i = j
+	}
+	switch len(runes) {
+	case 0:
+	case 1:
+		branches = append(branches, regexp.QuoteMeta(string(runes[0])))
+	default:
+		branches = append(branches, buildCharClass(runes))
+	}
+
+	pattern := strings.Join(branches, "|")
+	// A single rune or character class doesn't need a group
+	if len(branches) > 1 || (len(runes) == 0 && optional) {
+		pattern = "(" + pattern + ")"
+	}
+	if optional {
+		pattern += "?"
+	}
+	return pattern
+}
+
+// Build the character class of the sorted runes, the consecutive runes are merged into ranges, e.g. `[0-9A]`
+func buildCharClass(runes []rune) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i := 0; i < len(runes); {
+		j := i
+		for j+1 < len(runes) && runes[j+1] == runes[j]+1 {
+			j++
+		}
+		writeClassRune(&b, runes[i])
+		if j-i >= 2 {
+			b.WriteByte('-')
+			writeClassRune(&b, runes[j])
+		} else if j > i {
+			writeClassRune(&b, runes[j])
+		}
+		i = j + 1
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func writeClassRune(b *strings.Builder, r rune) {
+	if strings.ContainsRune(`\]-^[`, r) {
+		b.WriteByte('\\')
+	}
+	b.WriteRune(r)
+}
+
+// Get the common prefix of the first and the last of the sorted names, which is shared by all of them.
+// It ends at a rune boundary so the multi-byte runes are not split in the pattern.
+func commonPrefix(first string, last string) string {
+	n := 0
+	for n < len(first) && n < len(last) && first[n] == last[n] {
+		n++
+	}
+	for n > 0 && n < len(first) && !utf8.RuneStart(first[n]) {
+		n--
+	}
+	return first[:n]
+}
@@ -0,0 +1,134 @@
+package gotest_labels
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBuildTriePattern(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{names: []string{"TestA"}, want: "TestA"},
+		{names: []string{"TestApiCreate", "TestApiDelete", "TestDb"}, want: "Test(Api(Create|Delete)|Db)"},
+		{names: []string{"TestA", "TestAB"}, want: "TestAB?"},
+		{names: []string{"TestA", "TestApi"}, want: "TestA(pi)?"},
+		{names: []string{"TestA", "TestB", "TestC", "TestE"}, want: "Test[A-CE]"},
+		{names: []string{"TestA", "TestA", "TestB"}, want: "Test[AB]"},
+		{names: []string{"Test.x", "Test-x", "Test+y"}, want: `Test(\+y|-x|\.x)`},
+		{names: []string{"a]", "a-", "a^", `a\`}, want: `a[\-\\-\^]`},
+		{names: []string{"Testé1", "Testè2"}, want: "Test(è2|é1)"},
+		{names: nil, want: ""},
+	}
+	for _, tt := range tests {
+		if got := buildTriePattern(tt.names); got != tt.want {
+			t.Errorf("buildTriePattern(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+// The trie pattern matches exactly the same names as the flat alternation of the quoted names, checked on random
+// sets of names sharing prefixes, with regex meta characters and multi-byte runes.
+func TestBuildTriePatternEquivalence(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	parts := []string{"Test", "Api", "A", "B", "C", "0", "1", "2", "9", "_", "-", ".", "+", "*", "?", "[", "]", "^",
+		"$", `\`, "(", ")", "|", "é", "世", "�"}
+	randomName := func() string {
+		var b strings.Builder
+		for range rng.IntN(6) + 1 {
+			b.WriteString(parts[rng.IntN(len(parts))])
+		}
+		return b.String()
+	}
+
+	for i := range 500 {
+		var names []string
+		for range rng.IntN(30) + 1 {
+			names = append(names, randomName())
+		}
+		pattern := buildAlternationPattern(names)
+		trie, err := regexp.Compile(pattern)
+		if err != nil {
+			t.Fatalf("#%d: the pattern %q of %q doesn't compile: %v", i, pattern, names, err)
+		}
+		flat := buildFlatPattern(names)
+
+		// go test splits the pattern by the unbracketed `/` and `|`, the pattern of one level mustn't be split
+		if split := splitNamePattern(pattern); len(split) != 1 || len(split[0]) != 1 {
+			t.Fatalf("#%d: the pattern %q of %q is split by go test into %q", i, pattern, names, split)
+		}
+
+		var probes []string
+		for _, name := range names {
+			probes = append(probes, name, name+"A", "A"+name, name[:len(name)/2], randomName())
+			for j := range name {
+				probes = append(probes, name[:j], name[j:])
+			}
+		}
+		for _, probe := range probes {
+			// The test names are valid UTF-8, regexp matches an invalid byte like the replacement rune
+			if !utf8.ValidString(probe) {
+				continue
+			}
+			want := flat.MatchString(probe)
+			if got := trie.MatchString(probe); got != want {
+				t.Fatalf("#%d: the pattern %q of %q matches %q = %v, want %v", i, pattern, names, probe, got, want)
+			}
+			if slices.Contains(names, probe) != want {
+				t.Fatalf("#%d: the flat pattern of %q matches %q = %v", i, names, probe, want)
+			}
+		}
+	}
+}
+
+func TestBuildTriePatternSize(t *testing.T) {
+	var names []string
+	for _, resource := range []string{"Account", "Bucket", "Cluster", "Database", "Endpoint"} {
+		for _, action := range []string{"Create", "Delete", "Get", "List", "Update"} {
+			for i := range 80 {
+				names = append(names, fmt.Sprintf("TestApi%s%s%02d", resource, action, i))
+			}
+		}
+	}
+	pattern := buildAlternationPattern(names)
+	flat := buildFlatPattern(names).String()
+	if len(pattern)*10 > len(flat) {
+		t.Errorf("Expected the pattern of %d names to be less than 1/10 of the flat pattern, got %d and %d",
+			len(names), len(pattern), len(flat))
+	}
+}
+
+// The flat alternation of the quoted names, which the trie pattern shall be equivalent to
+func buildFlatPattern(names []string) *regexp.Regexp {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	return regexp.MustCompile("^(" + strings.Join(quoted, "|") + ")$")
+}
+
+// The cost of matching the test names by the pattern of 2,000 tests
+func BenchmarkTestNamePatternMatch(b *testing.B) {
+	var names []string
+	for i := range 2000 {
+		names = append(names, fmt.Sprintf("TestApi%sCase%04d", []string{"Create", "Delete", "Get", "List"}[i%4], i))
+	}
+	for name, regex := range map[string]*regexp.Regexp{
+		"flat": buildFlatPattern(names),
+		"trie": regexp.MustCompile(buildAlternationPattern(names)),
+	} {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				for _, name := range names[:100] {
+					regex.MatchString(name)
+				}
+			}
+		})
+	}
+}